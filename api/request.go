@@ -13,6 +13,10 @@ type ExecReq struct {
 
 	Tests []Test `json:"tests"`
 
+	// Archive packs all tests into a single download. When set,
+	// Tests are taken from its manifest and must be left empty.
+	Archive *TestArchive `json:"archive,omitempty"`
+
 	Checker    *string `json:"checker"`
 	Interactor *string `json:"interactor"`
 
@@ -20,6 +24,29 @@ type ExecReq struct {
 	CpuMs int32 `json:"cpu_ms"`
 	// Kibibytes are more precise than kilobytes
 	RamKiB int32 `json:"ram_kib"`
+
+	// Truncation bounds what truncated tests show;
+	// MaxRuntimeDataHeight by MaxRuntimeDataWidth if nil
+	Truncation *Truncation `json:"truncation,omitempty"`
+}
+
+// Visibility is how much of a test the stream messages of a job show
+type Visibility string
+
+const (
+	// VisibilityFull shows the input, answer and outputs as they are, e.g. of samples
+	VisibilityFull Visibility = "full"
+	// VisibilityTruncated cuts them to the Truncation of the request. Default.
+	VisibilityTruncated Visibility = "truncated"
+	// VisibilityHidden leaves them out; only verdict data such as the
+	// exit code, time and memory is shown
+	VisibilityHidden Visibility = "hidden"
+)
+
+// Truncation keeps the first Height lines of Width bytes
+type Truncation struct {
+	Height int `json:"height"`
+	Width  int `json:"width"`
 }
 
 // Test or test case is a pair of input and answer
@@ -27,6 +54,32 @@ type ExecReq struct {
 type Test struct {
 	In  File `json:"in"`
 	Ans File `json:"ans"`
+
+	Visibility Visibility `json:"visibility,omitempty"`
+}
+
+// TestArchive is a tar, tar.zst, tar.gz or zip file holding the tests.
+// Like any File, a URL ending in .zst is decompressed on download,
+// so its sha256 is that of the plain tar.
+type TestArchive struct {
+	File
+	// Manifest lists the tests in order; test IDs start at 1
+	Manifest []ArchiveTest `json:"manifest"`
+}
+
+// ArchiveTest names the input and answer of a test inside the archive
+type ArchiveTest struct {
+	In  string `json:"in"`
+	Ans string `json:"ans"`
+
+	Visibility Visibility `json:"visibility,omitempty"`
+}
+
+// PrefetchReq asks a tester to download files into its cache
+// before the submissions that need them arrive, e.g. at contest start
+type PrefetchReq struct {
+	Uuid  string `json:"uuid"`
+	Files []File `json:"files"`
 }
 
 type File struct {
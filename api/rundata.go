@@ -1,5 +1,16 @@
 package api
 
+// IsolateStatus is the two-letter status isolate reports in its meta file
+type IsolateStatus string
+
+const (
+	IsolateOK            IsolateStatus = "OK" // exited with status 0
+	IsolateRuntimeError  IsolateStatus = "RE" // exited with non-zero status
+	IsolateSignaled      IsolateStatus = "SG" // died on a signal
+	IsolateTimedOut      IsolateStatus = "TO" // cpu or wall time limit exceeded
+	IsolateInternalError IsolateStatus = "XX" // sandbox failure, not the program's fault
+)
+
 // RuntimeData contains execution information for a process (streaming version)
 type RuntimeData struct {
 	Stdin    string `json:"in"`
@@ -10,13 +21,48 @@ type RuntimeData struct {
 	CpuMillis  int64 `json:"cpu_ms"`
 	WallMillis int64 `json:"wall_ms"`
 	RamKiBytes int64 `json:"ram_kib"`
+	MaxRssKiB  int64 `json:"max_rss_kib"`
 
 	CtxSwV int64 `json:"ctx_sw_v"`
 	CtxSwF int64 `json:"ctx_sw_f"`
 
 	ExitSignal  *int64 `json:"signal"`
 	CgOomKilled bool   `json:"cg_oom_killed"` // killed on memory allocation?
+	Killed      bool   `json:"killed"`        // killed by isolate (e.g. on timeout)?
+
+	IsolateStatus *IsolateStatus `json:"isolate_status"` // null if the program exited normally
+	IsolateMsg    *string        `json:"isolate_msg"`
+	IsolateMeta   string         `json:"isolate_meta"` // raw meta file contents
+
+	// sizes of the outputs as the program wrote them, if Stdout and
+	// Stderr only hold their first MiB; 0 if they were captured whole
+	StdoutOrigSize int64 `json:"out_orig_size,omitempty"`
+	StderrOrigSize int64 `json:"err_orig_size,omitempty"`
+
+	// Stdout and Stderr as captured, if trimmed for the message and uploaded
+	StdoutRef *OutputRef `json:"out_ref,omitempty"`
+	StderrRef *OutputRef `json:"err_ref,omitempty"`
+}
+
+// Status is the isolate status, IsolateOK if isolate reported none
+func (d *RuntimeData) Status() IsolateStatus {
+	if d.IsolateStatus == nil {
+		return IsolateOK
+	}
+	return *d.IsolateStatus
+}
+
+// OutputEncodingZstd is the encoding of outputs uploaded by the tester
+const OutputEncodingZstd = "zstd"
 
-	IsolateStatus *string `json:"isolate_status"`
-	IsolateMsg    *string `json:"isolate_msg"`
+// OutputRef points to an output kept in object storage under its sha256.
+// The object holds the output as captured, which is only the head of
+// a longer output if Truncated is set.
+type OutputRef struct {
+	Sha256    string `json:"sha256"`              // of the uncompressed object
+	Size      int64  `json:"size"`                // uncompressed size of the object in bytes
+	Url       string `json:"url"`                 // s3://bucket/key unless a public URL is configured
+	Encoding  string `json:"encoding,omitempty"`  // compression of the object, e.g. OutputEncodingZstd
+	Truncated bool   `json:"truncated,omitempty"` // the program wrote more than was captured
+	OrigSize  int64  `json:"orig_size,omitempty"` // size of the whole output if Truncated
 }
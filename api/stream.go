@@ -14,9 +14,16 @@ const (
 	IgnoreTestMsg    MsgType = "test_ignore"
 	FinishTestMsg    MsgType = "test_finish"
 	FinishJobMsg     MsgType = "job_finish"
+
+	// FinishPrefetchMsg answers a PrefetchReq; EvalUuid holds its uuid
+	FinishPrefetchMsg MsgType = "prefetch_finish"
+
+	// Sent by the client over a bidirectional channel, e.g. WebSocket
+	SubmitJobMsg MsgType = "job_submit"
+	CancelJobMsg MsgType = "job_cancel"
 )
 
-// Runtime data size constraints for streaming
+// Default Truncation of the inputs, answers and outputs in stream messages
 const (
 	MaxRuntimeDataHeight = 40
 	MaxRuntimeDataWidth  = 80
@@ -76,6 +83,29 @@ type FinishJob struct {
 	InternalError bool    `json:"internal_error"`
 }
 
+// FinishPrefetch message sent when all files of a PrefetchReq
+// are present in the cache of the worker or have failed
+type FinishPrefetch struct {
+	Header
+	Worker       string   `json:"worker"` // hostname of the replying worker
+	Present      int      `json:"present"`
+	Failed       []string `json:"failed"` // sha256 of files that are missing
+	ErrorMessage *string  `json:"error_message"`
+}
+
+// SubmitJob message sent by the client to run a job. The job's uuid
+// is taken from the header; the worker generates one if it is empty.
+type SubmitJob struct {
+	Header
+	Job ExecReq `json:"job"`
+}
+
+// CancelJob message sent by the client to stop a submitted job.
+// The job finishes with an internal error.
+type CancelJob struct {
+	Header
+}
+
 // Helper function to create a header
 func NewHeader(evalUuid string, msgType MsgType) Header {
 	return Header{
@@ -139,3 +169,13 @@ func NewFinishJob(evalUuid string, errorMessage *string, compileError, internalE
 		InternalError: internalError,
 	}
 }
+
+func NewFinishPrefetch(prefetchUuid, worker string, present int, failed []string, errorMessage *string) FinishPrefetch {
+	return FinishPrefetch{
+		Header:       NewHeader(prefetchUuid, FinishPrefetchMsg),
+		Worker:       worker,
+		Present:      present,
+		Failed:       failed,
+		ErrorMessage: errorMessage,
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal/gatherer/archivegath"
+	"github.com/urfave/cli/v3"
+)
+
+// historyTimeFormat is how cmdHistory prints the time of an event
+const historyTimeFormat = "2006-01-02 15:04:05.000"
+
+// cmdHistory prints the archived events of a job, as a table or as the
+// archive records themselves, which hold the outputs as captured
+func cmdHistory(archiveDir string, evalUuid string, asJSON bool) error {
+	if archiveDir == "" {
+		return cli.Exit("--archive-dir is required (env: TESTER_ARCHIVE_DIR)", 1)
+	}
+	records, err := archivegath.Find(archiveDir, evalUuid)
+	if err != nil && len(records) == 0 {
+		return cli.Exit(err.Error(), 1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+	}
+	if len(records) == 0 {
+		return cli.Exit(fmt.Sprintf("job %s is not in the archive", evalUuid), 1)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEVENT\tTEST\tDETAILS")
+	for _, r := range records {
+		test, details := describeRecord(r)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Time.Local().Format(historyTimeFormat), r.MsgType, test, details)
+	}
+	return w.Flush()
+}
+
+// describeRecord summarises an archived event in a line
+func describeRecord(r archivegath.Record) (test string, details string) {
+	switch r.MsgType {
+	case api.StartJobMsg:
+		if r.Job != nil {
+			details = fmt.Sprintf("%s, %d tests, %d ms, %d KiB", r.Job.Lang.LangName, len(r.Job.Tests), r.Job.CpuMs, r.Job.RamKiB)
+			if r.Job.Archive != nil {
+				details = fmt.Sprintf("%s, %d tests in an archive, %d ms, %d KiB", r.Job.Lang.LangName, len(r.Job.Archive.Manifest), r.Job.CpuMs, r.Job.RamKiB)
+			}
+		}
+	case api.FinishCompileMsg:
+		var msg api.FinishCompile
+		if json.Unmarshal(r.Msg, &msg) == nil && msg.RuntimeData != nil {
+			details = describeRun(msg.RuntimeData)
+		}
+	case api.ReachTestMsg:
+		var msg api.ReachTest
+		if json.Unmarshal(r.Msg, &msg) == nil {
+			test = fmt.Sprint(msg.TestId)
+		}
+		details = fmt.Sprintf("input %s, answer %s", describeTestFile(r.Input), describeTestFile(r.Answer))
+	case api.IgnoreTestMsg:
+		var msg api.IgnoreTest
+		if json.Unmarshal(r.Msg, &msg) == nil {
+			test = fmt.Sprint(msg.TestId)
+		}
+	case api.FinishTestMsg:
+		var msg api.FinishTest
+		if json.Unmarshal(r.Msg, &msg) == nil {
+			test = fmt.Sprint(msg.TestId)
+			details = describeRun(msg.Submission)
+			if msg.Checker != nil {
+				details += "; checker: " + firstLine(msg.Checker.Stderr)
+			}
+		}
+	case api.FinishJobMsg:
+		var msg api.FinishJob
+		if json.Unmarshal(r.Msg, &msg) == nil {
+			switch {
+			case msg.CompileError:
+				details = "compile error"
+			case msg.InternalError:
+				details = "internal error"
+			default:
+				details = "finished"
+			}
+			if msg.ErrorMessage != nil {
+				details += ": " + firstLine(*msg.ErrorMessage)
+			}
+		}
+	}
+	return test, details
+}
+
+func describeRun(d *api.RuntimeData) string {
+	if d == nil {
+		return ""
+	}
+	output := fmt.Sprintf("%d bytes of output", len(d.Stdout))
+	if d.StdoutOrigSize > 0 {
+		output = fmt.Sprintf("%d bytes of output, first %d archived", d.StdoutOrigSize, len(d.Stdout))
+	}
+	return fmt.Sprintf("%s exit %d, %d ms, %d KiB, %s",
+		d.Status(), d.ExitCode, d.CpuMillis, d.MaxRssKiB, output)
+}
+
+func describeTestFile(f *archivegath.TestFile) string {
+	switch {
+	case f == nil:
+		return "?"
+	case f.Archive != "":
+		return f.Name
+	case len(f.Sha256) >= 8:
+		return f.Sha256[:8]
+	default:
+		return "?"
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
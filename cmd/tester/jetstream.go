@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal/gatherer/natsgath"
+	testerpkg "github.com/programme-lv/tester/internal/tester"
+)
+
+// jsOpts configures the JetStream mode of `tester listen nats`
+type jsOpts struct {
+	stream         string        // holds the jobs; created as a work queue if missing
+	consumer       string        // durable pull consumer shared by the workers
+	maxDeliver     int           // deliveries of a job before it is dead-lettered
+	ackWait        time.Duration // redelivery delay of an unacknowledged job
+	dlqSubject     string        // receives poison jobs
+	resultsSubject string        // results of a job go to <resultsSubject>.<uuid>
+	resultsStream  string        // created if missing to keep the results
+}
+
+// Headers of dead-lettered jobs
+const (
+	dlqErrorHeader     = "Tester-Error"
+	dlqStreamSeqHeader = "Tester-Stream-Seq"
+	dlqDeliveredHeader = "Tester-Delivered"
+)
+
+// jsFetchWait is how long a single pull for the next job waits
+const jsFetchWait = 30 * time.Second
+
+// minAckWait keeps the heartbeat, sent every third of --ack-wait,
+// at least a second apart
+const minAckWait = 3 * time.Second
+
+// consumeJetStream runs jobs from a durable pull consumer. A job is
+// acknowledged once its FinishJob has been published, so a job of a worker
+// that crashed is redelivered after ackWait. Jobs that cannot be decoded,
+// or that have been delivered maxDeliver times, go to the dead-letter subject.
+func consumeJetStream(t *testerpkg.Tester, nc *nats.Conn, js jetstream.JetStream, subject string, opts jsOpts, jobTimeout time.Duration) {
+	ctx := context.Background()
+
+	stream := ensureStream(ctx, js, jetstream.StreamConfig{
+		Name:      opts.stream,
+		Subjects:  []string{subject},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	ensureStream(ctx, js, jetstream.StreamConfig{
+		Name:     opts.stream + "_DLQ",
+		Subjects: []string{opts.dlqSubject},
+	})
+	if opts.resultsStream != "" {
+		ensureStream(ctx, js, jetstream.StreamConfig{
+			Name:     opts.resultsStream,
+			Subjects: []string{opts.resultsSubject + ".>"},
+			MaxAge:   24 * time.Hour,
+		})
+	}
+
+	cons, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       opts.consumer,
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       opts.ackWait,
+		MaxDeliver:    opts.maxDeliver,
+	})
+	if err != nil {
+		log.Fatalf("failed to create consumer %s: %v", opts.consumer, err)
+	}
+
+	// the server gives up on a job that keeps crashing workers; keep it
+	advisory := fmt.Sprintf("$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.%s.%s", opts.stream, opts.consumer)
+	_, err = nc.QueueSubscribe(advisory, opts.consumer, func(m *nats.Msg) {
+		deadLetterExhausted(js, stream, opts, m.Data)
+	})
+	if err != nil {
+		log.Fatalf("failed to subscribe to max deliveries advisory: %v", err)
+	}
+
+	log.Printf("worker consuming stream=%q consumer=%q max_deliver=%d results=%q",
+		opts.stream, opts.consumer, opts.maxDeliver, opts.resultsSubject+".<uuid>")
+	for {
+		batch, err := cons.Fetch(1, jetstream.FetchMaxWait(jsFetchWait))
+		if err != nil {
+			log.Printf("failed to fetch job: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for msg := range batch.Messages() {
+			runJetStreamJob(t, js, msg, opts, jobTimeout)
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			log.Printf("failed to fetch job: %v", err)
+		}
+	}
+}
+
+func runJetStreamJob(t *testerpkg.Tester, js jetstream.JetStream, msg jetstream.Msg, opts jsOpts, jobTimeout time.Duration) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Printf("failed to read job metadata: %v", err)
+		return
+	}
+
+	var request api.ExecReq
+	err = decodeRequest(msg.Data(), &request)
+	if err == nil && request.Uuid == "" {
+		err = errors.New("job has no uuid")
+	} else if err == nil {
+		// the uuid becomes a token of the results subject
+		if verr := uuid.Validate(request.Uuid); verr != nil {
+			err = fmt.Errorf("invalid uuid %q: %w", request.Uuid, verr)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to decode job %d: %v", meta.Sequence.Stream, err)
+		deadLetter(js, opts, msg.Data(), err.Error(), meta.Sequence.Stream, meta.NumDelivered)
+		if err := msg.TermWithReason(err.Error()); err != nil {
+			log.Printf("failed to terminate job %d: %v", meta.Sequence.Stream, err)
+		}
+		return
+	}
+
+	log.Printf("received job %s (delivery %d of %d)", request.Uuid, meta.NumDelivered, opts.maxDeliver)
+	stop := heartbeat(msg, opts.ackWait/3)
+	// without a results stream nothing would store a JetStream publish
+	resultsSubject := opts.resultsSubject + "." + request.Uuid
+	gatherer := natsgath.New(js.Conn(), request.Uuid, resultsSubject)
+	if opts.resultsStream != "" {
+		gatherer = natsgath.NewJetStream(js, request.Uuid, resultsSubject)
+	}
+	ctx, cancel := jobContext(jobTimeout)
+	if err := t.ExecTests(ctx, gatherer, request); err != nil {
+		log.Printf("error executing tests: %v", err)
+	}
+	cancel()
+	stop()
+
+	// FinishJob has been published; the job has its answer either way
+	if err := msg.DoubleAck(context.Background()); err != nil {
+		log.Printf("failed to ack job %s: %v", request.Uuid, err)
+	}
+}
+
+// heartbeat tells the server that a long job is still running,
+// so that it is not redelivered to another worker
+func heartbeat(msg jetstream.Msg, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					log.Printf("failed to extend job ack deadline: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// deadLetterExhausted moves a job the server will no longer deliver
+// from the work queue to the dead-letter subject
+func deadLetterExhausted(js jetstream.JetStream, stream jetstream.Stream, opts jsOpts, advisory []byte) {
+	var event struct {
+		StreamSeq  uint64 `json:"stream_seq"`
+		Deliveries uint64 `json:"deliveries"`
+	}
+	if err := json.Unmarshal(advisory, &event); err != nil {
+		log.Printf("failed to decode max deliveries advisory: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	raw, err := stream.GetMsg(ctx, event.StreamSeq)
+	if err != nil {
+		log.Printf("failed to get exhausted job %d: %v", event.StreamSeq, err)
+		return
+	}
+	reason := fmt.Sprintf("not acknowledged after %d deliveries", event.Deliveries)
+	log.Printf("job %d was %s", event.StreamSeq, reason)
+	if !deadLetter(js, opts, raw.Data, reason, event.StreamSeq, event.Deliveries) {
+		return
+	}
+	if err := stream.DeleteMsg(ctx, event.StreamSeq); err != nil {
+		log.Printf("failed to delete exhausted job %d: %v", event.StreamSeq, err)
+	}
+}
+
+// deadLetter publishes a poison job with the reason in its headers.
+// Reports whether the dead-letter stream has stored it.
+func deadLetter(js jetstream.JetStream, opts jsOpts, data []byte, reason string, streamSeq, delivered uint64) bool {
+	m := nats.NewMsg(opts.dlqSubject)
+	m.Data = data
+	m.Header.Set(dlqErrorHeader, reason)
+	m.Header.Set(dlqStreamSeqHeader, strconv.FormatUint(streamSeq, 10))
+	m.Header.Set(dlqDeliveredHeader, strconv.FormatUint(delivered, 10))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := js.PublishMsg(ctx, m); err != nil {
+		log.Printf("failed to dead-letter job %d: %v", streamSeq, err)
+		return false
+	}
+	return true
+}
+
+// ensureStream returns the stream, creating it with cfg if it does not exist.
+// An existing stream is left as configured by its operator.
+func ensureStream(ctx context.Context, js jetstream.JetStream, cfg jetstream.StreamConfig) jetstream.Stream {
+	stream, err := js.Stream(ctx, cfg.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		log.Printf("creating stream %s for %v", cfg.Name, cfg.Subjects)
+		stream, err = js.CreateStream(ctx, cfg)
+	}
+	if err != nil {
+		log.Fatalf("failed to get stream %s: %v", cfg.Name, err)
+	}
+	return stream
+}
@@ -2,31 +2,47 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"log/slog"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/fatih/color"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/klauspost/compress/zstd"
 	"github.com/lmittmann/tint"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
 	"github.com/programme-lv/tester/internal/behave"
 	"github.com/programme-lv/tester/internal/filecache"
+	"github.com/programme-lv/tester/internal/gatherer/archivegath"
 	"github.com/programme-lv/tester/internal/gatherer/natsgath"
 	"github.com/programme-lv/tester/internal/gatherer/respbuilder"
-	"github.com/programme-lv/tester/internal/gatherer/sqsgath"
+	"github.com/programme-lv/tester/internal/gatherer/uploadgath"
+	"github.com/programme-lv/tester/internal/httpexec"
 	"github.com/programme-lv/tester/internal/isolate"
+	"github.com/programme-lv/tester/internal/sandbox"
+	"github.com/programme-lv/tester/internal/sandbox/fake"
+	"github.com/programme-lv/tester/internal/syscheck"
 	testerpkg "github.com/programme-lv/tester/internal/tester"
 	"github.com/programme-lv/tester/internal/testlib"
 	"github.com/programme-lv/tester/internal/utils"
@@ -34,6 +50,9 @@ import (
 	"github.com/urfave/cli/v3"
 )
 
+// configDir holds system.txt, testlib.h and behave.toml installed by scripts/install.sh
+const configDir = "/usr/local/etc/tester"
+
 func main() {
 	// Load .env file early so env vars are available for flag defaults
 	if err := godotenv.Load(); err != nil {
@@ -53,6 +72,27 @@ func main() {
 	root := &cli.Command{
 		Name:  "tester",
 		Usage: "code execution worker",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "sandbox", Value: "isolate", Usage: "sandbox backend: isolate, or fake (no isolation; for CI only)"},
+			&cli.StringFlag{Name: "cache-max-size", Value: "0", Usage: "disk budget of the test file cache, e.g. 20G; 0 means unlimited"},
+			&cli.StringFlag{Name: "io-mode", Value: string(testerpkg.IOFiles), Usage: "checker variant I/O: files (isolate redirection) or pipes"},
+			&cli.BoolFlag{Name: "cache-compress", Usage: "keep cached test files zstd compressed on disk"},
+			&cli.IntFlag{Name: "download-workers", Value: 4, Usage: "number of test files downloaded in parallel"},
+			&cli.BoolFlag{Name: "allow-http", Usage: "accept plain http:// test URLs (development only)"},
+			&cli.StringFlag{Name: "file-root", Usage: "accept file:// test URLs below this directory"},
+			&cli.StringFlag{Name: "s3-endpoint", Value: os.Getenv("S3_ENDPOINT"), Usage: "S3-compatible endpoint for s3:// test URLs and --outputs-bucket, e.g. http://localhost:9000 for MinIO; AWS S3 if empty (env: S3_ENDPOINT)"},
+			&cli.StringFlag{Name: "s3-region", Value: getAWSRegion(), Usage: "region for s3:// test URLs and --outputs-bucket (env: AWS_REGION)"},
+			&cli.StringFlag{Name: "peer-listen", Usage: "serve cached test files to other workers on this address, e.g. :8091"},
+			&cli.StringFlag{Name: "peer-url", Usage: "URL other workers reach --peer-listen at; http://<hostname>:<port> if empty"},
+			&cli.StringFlag{Name: "peers", Usage: "comma separated URLs of workers to ask for test files before their origin"},
+			&cli.StringFlag{Name: "peer-token", Value: os.Getenv("TESTER_PEER_TOKEN"), Usage: "bearer token between peers (env: TESTER_PEER_TOKEN)"},
+			&cli.StringFlag{Name: "archive-dir", Value: os.Getenv("TESTER_ARCHIVE_DIR"), Usage: "keep the full results of every job in daily JSONL files here; none if empty (env: TESTER_ARCHIVE_DIR)"},
+			&cli.BoolFlag{Name: "archive-compress", Usage: "zstd compress new archive files"},
+			&cli.DurationFlag{Name: "job-timeout", Value: 30 * time.Minute, Usage: "give up a job of a listener after this long, reporting an internal error; 0 means never"},
+			&cli.StringFlag{Name: "outputs-bucket", Value: os.Getenv("TESTER_OUTPUTS_BUCKET"), Usage: "upload outputs that stream messages truncate to this bucket of --s3-endpoint; none if empty (env: TESTER_OUTPUTS_BUCKET)"},
+			&cli.StringFlag{Name: "outputs-prefix", Value: "outputs/", Usage: "key prefix of uploaded outputs"},
+			&cli.StringFlag{Name: "outputs-url", Usage: "public URL of the outputs bucket used in links; s3://<bucket> if empty"},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:      "verify",
@@ -65,13 +105,86 @@ func main() {
 				Action: func(ctx context.Context, c *cli.Command) error {
 					if c.NArg() < 1 {
 						// Fallback to default behave.toml if present
-						fallback := "/usr/local/etc/tester/behave.toml"
+						fallback := configDir + "/behave.toml"
 						if _, err := os.Stat(fallback); err == nil {
-							return cmdVerify(fallback, c.Bool("verbose"), c.Bool("no-color"))
+							return cmdVerify(testerOptsFrom(c), fallback, c.Bool("verbose"), c.Bool("no-color"))
 						}
 						return cli.Exit("path to behave.toml is required; default not found; see --help", 1)
 					}
-					return cmdVerify(c.Args().First(), c.Bool("verbose"), c.Bool("no-color"))
+					return cmdVerify(testerOptsFrom(c), c.Args().First(), c.Bool("verbose"), c.Bool("no-color"))
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Inspect and maintain the test file, checker and interactor caches",
+				Commands: []*cli.Command{
+					{
+						Name:  "ls",
+						Usage: "List cached files and compiled binaries with their size and last use",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							return cmdCacheLs()
+						},
+					},
+					{
+						Name:  "stats",
+						Usage: "Summarise the disk usage of the caches",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							return cmdCacheStats()
+						},
+					},
+					{
+						Name:  "gc",
+						Usage: "Remove least recently used test files until the file cache fits into a budget",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "max-size", Required: true, Usage: "disk budget of the test file cache, e.g. 20G"},
+						},
+						Action: func(ctx context.Context, c *cli.Command) error {
+							return cmdCacheGC(c.String("max-size"))
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "Re-hash every cached file and quarantine mismatches",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							return cmdCacheVerify()
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove cached files or compiled binaries by their sha256",
+						ArgsUsage: "<sha256>...",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							if c.NArg() < 1 {
+								return cli.Exit("at least one sha256 is required; see --help", 1)
+							}
+							return cmdCacheRm(c.Args().Slice())
+						},
+					},
+					{
+						Name:      "import",
+						Usage:     "Store the files of a local directory in the file cache by their sha256",
+						ArgsUsage: "<dir>",
+						Action: func(ctx context.Context, c *cli.Command) error {
+							if c.NArg() < 1 {
+								return cli.Exit("path to a directory is required; see --help", 1)
+							}
+							return cmdCacheImport(c.Args().First(), c.Bool("cache-compress"))
+						},
+					},
+				},
+			},
+			{
+				Name:      "history",
+				Usage:     "Print the archived results of a job from --archive-dir",
+				ArgsUsage: "<uuid>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "print the archive records, with the outputs as captured (up to 1 MiB each), as JSON lines"},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					if c.NArg() < 1 {
+						return cli.Exit("uuid of a job is required; see --help", 1)
+					}
+					return cmdHistory(c.String("archive-dir"), c.Args().First(), c.Bool("json"))
 				},
 			},
 			{
@@ -80,9 +193,57 @@ func main() {
 				Commands: []*cli.Command{
 					{
 						Name:  "sqs",
-						Usage: "Listen to AWS SQS queues",
+						Usage: "Listen to AWS SQS queues; SIGTERM finishes running jobs before exiting",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "queue-url", Value: os.Getenv("SUBM_REQ_QUEUE_URL"), Usage: "queue of the jobs (env: SUBM_REQ_QUEUE_URL)"},
+							&cli.StringFlag{Name: "response-url", Value: os.Getenv("RESPONSE_QUEUE_URL"), Usage: "queue receiving the results (env: RESPONSE_QUEUE_URL)"},
+							&cli.StringFlag{Name: "dlq-url", Value: os.Getenv("SQS_DLQ_URL"), Usage: "queue for jobs that cannot be run; they are only logged and deleted if empty (env: SQS_DLQ_URL)"},
+							&cli.StringFlag{Name: "endpoint", Value: os.Getenv("SQS_ENDPOINT"), Usage: "SQS-compatible endpoint, e.g. http://localhost:9324 for ElasticMQ; AWS SQS if empty (env: SQS_ENDPOINT)"},
+							&cli.StringFlag{Name: "region", Value: getAWSRegion(), Usage: "region of the queues (env: AWS_REGION)"},
+							&cli.IntFlag{Name: "concurrency", Value: 1, Usage: "number of jobs run at once"},
+							&cli.DurationFlag{Name: "visibility-timeout", Value: time.Minute, Usage: "redeliver a job if its worker has not reported progress for this long"},
+							&cli.IntFlag{Name: "max-receives", Value: 3, Usage: "receives of a job before it is dead-lettered"},
+						},
 						Action: func(ctx context.Context, c *cli.Command) error {
-							cmdListenSQS()
+							cmdListenSQS(testerOptsFrom(c), sqsOpts{
+								queueURL:    c.String("queue-url"),
+								responseURL: c.String("response-url"),
+								dlqURL:      c.String("dlq-url"),
+								endpoint:    c.String("endpoint"),
+								region:      c.String("region"),
+								concurrency: c.Int("concurrency"),
+								visibility:  c.Duration("visibility-timeout"),
+								maxReceives: c.Int("max-receives"),
+							})
+							return nil
+						},
+					},
+					{
+						Name:  "http",
+						Usage: "Serve jobs over HTTP: POST /exec, POST /exec/stream for Server-Sent Events, or a WebSocket at /ws",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "addr", Value: ":8080", Usage: "address to listen on"},
+							&cli.IntFlag{Name: "concurrency", Value: 1, Usage: "number of jobs run at once; others wait"},
+							&cli.StringFlag{Name: "tokens", Value: os.Getenv("TESTER_HTTP_TOKENS"), Usage: "comma separated bearer tokens accepted; no auth if empty (env: TESTER_HTTP_TOKENS)"},
+							&cli.StringFlag{Name: "ws-origins", Usage: "comma separated origins of other sites allowed to open the WebSocket, e.g. ide.programme.lv"},
+						},
+						Action: func(ctx context.Context, c *cli.Command) error {
+							cmdListenHTTP(testerOptsFrom(c), c.String("addr"), c.Int("concurrency"), c.String("tokens"), c.String("ws-origins"))
+							return nil
+						},
+					},
+					{
+						Name:      "dir",
+						Usage:     "Run jobs dropped as ExecReq JSON (optionally .json.zst) into <path>/incoming; results go to <path>/done or <path>/failed",
+						ArgsUsage: "<path>",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{Name: "poll", Value: time.Second, Usage: "how often to look for new jobs when idle"},
+						},
+						Action: func(ctx context.Context, c *cli.Command) error {
+							if c.NArg() < 1 {
+								return cli.Exit("path to a spool directory is required; see --help", 1)
+							}
+							cmdListenDir(testerOptsFrom(c), c.Args().First(), c.Duration("poll"))
 							return nil
 						},
 					},
@@ -93,9 +254,34 @@ func main() {
 							&cli.StringFlag{Name: "url", Value: getNATSURL(), Usage: "NATS server URL (env: NATS_URL)"},
 							&cli.StringFlag{Name: "subject", Value: "tester.jobs", Usage: "Subject to subscribe to"},
 							&cli.StringFlag{Name: "queue", Value: "workers", Usage: "Queue group name"},
+							&cli.StringFlag{Name: "prefetch-subject", Value: "tester.prefetch", Usage: "Subject for cache prefetch requests, received by every worker"},
+							&cli.StringFlag{Name: "peer-subject", Value: "tester.peers", Usage: "Subject on which workers announce their --peer-url to each other"},
+							&cli.BoolFlag{Name: "jetstream", Usage: "Consume jobs from a durable JetStream consumer instead of a queue group"},
+							&cli.StringFlag{Name: "stream", Value: "TESTER_JOBS", Usage: "JetStream: stream of the jobs, created as a work queue if missing"},
+							&cli.StringFlag{Name: "consumer", Value: "workers", Usage: "JetStream: durable consumer shared by the workers"},
+							&cli.IntFlag{Name: "max-deliver", Value: 3, Usage: "JetStream: deliveries of a job before it is dead-lettered"},
+							&cli.DurationFlag{Name: "ack-wait", Value: time.Minute, Usage: "JetStream: redeliver a job if its worker has not reported progress for this long"},
+							&cli.StringFlag{Name: "dlq-subject", Value: "tester.jobs.dlq", Usage: "JetStream: subject for jobs that cannot be run"},
+							&cli.StringFlag{Name: "results-subject", Value: "tester.results", Usage: "JetStream: results of a job are published to <results-subject>.<uuid>"},
+							&cli.StringFlag{Name: "results-stream", Value: "TESTER_RESULTS", Usage: "JetStream: stream keeping the results for replay, created if missing; none if empty"},
 						},
 						Action: func(ctx context.Context, c *cli.Command) error {
-							cmdListenNATS(c.String("url"), c.String("subject"), c.String("queue"))
+							var jsMode *jsOpts
+							if c.Bool("jetstream") {
+								if c.Duration("ack-wait") < minAckWait {
+									return cli.Exit(fmt.Sprintf("--ack-wait must be at least %s", minAckWait), 1)
+								}
+								jsMode = &jsOpts{
+									stream:         c.String("stream"),
+									consumer:       c.String("consumer"),
+									maxDeliver:     c.Int("max-deliver"),
+									ackWait:        c.Duration("ack-wait"),
+									dlqSubject:     c.String("dlq-subject"),
+									resultsSubject: c.String("results-subject"),
+									resultsStream:  c.String("results-stream"),
+								}
+							}
+							cmdListenNATS(testerOptsFrom(c), c.String("url"), c.String("subject"), c.String("queue"), c.String("prefetch-subject"), c.String("peer-subject"), jsMode)
 							return nil
 						},
 					},
@@ -109,70 +295,65 @@ func main() {
 	}
 }
 
-func cmdListenSQS() {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("eu-central-1"))
+func cmdListenHTTP(opts testerOpts, addr string, concurrency int, tokens string, wsOrigins string) {
+	t, filestore := buildTester(mustSandbox(opts.sandbox), opts)
+
+	accepted := splitList(tokens)
+	if len(accepted) == 0 {
+		log.Printf("WARNING: accepting jobs on %s without --tokens; anyone who can connect may run code", addr)
+	}
+
+	handler := httpexec.New(t, concurrency, accepted)
+	handler.SetOriginPatterns(splitList(wsOrigins))
+	handler.SetFileProgress(filestore)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("worker listening on %s with concurrency %d", addr, concurrency)
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve HTTP: %v", err)
+	}
+}
+
+// cmdListenNATS runs jobs from a core NATS queue group, or with jsMode
+// from a JetStream consumer, see consumeJetStream.
+func cmdListenNATS(opts testerOpts, natsURL, subject, queue, prefetchSubject, peerSubject string, jsMode *jsOpts) {
+	log.Printf("connecting to NATS at %s", redactURL(natsURL))
+	nc, err := nats.Connect(natsURL)
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
-	}
-	t, _, _ := buildTester()
-	submReqQueueUrl := mustEnv("SUBM_REQ_QUEUE_URL")
-	responseQueueUrl := mustEnv("RESPONSE_QUEUE_URL")
-
-	sqsClient := sqs.NewFromConfig(cfg)
-	for {
-		output, err := sqsClient.ReceiveMessage(context.TODO(), &sqs.ReceiveMessageInput{
-			QueueUrl:            aws.String(submReqQueueUrl),
-			MaxNumberOfMessages: 1,
-			WaitTimeSeconds:     5,
-		})
-		if err != nil {
-			log.Printf("failed to receive messages, %v", err)
-			time.Sleep(1 * time.Second)
-			continue
-		}
+		log.Fatalf("failed to connect to NATS: %v", err)
+	}
+	defer nc.Drain()
 
-		for _, message := range output.Messages {
-			// Decode base64
-			compressed, err := base64.StdEncoding.DecodeString(*message.Body)
-			if err != nil {
-				log.Printf("failed to decode base64 message: %v", err)
-				_, err = sqsClient.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
-					QueueUrl:      aws.String(submReqQueueUrl),
-					ReceiptHandle: message.ReceiptHandle,
-				})
-				if err != nil {
-					log.Printf("failed to delete message: %v", err)
-				}
-				continue
-			}
+	t, filestore := buildTester(mustSandbox(opts.sandbox), opts)
 
-			// Decompress zstd
-			decoder, err := zstd.NewReader(nil)
-			if err != nil {
-				log.Printf("failed to create zstd decoder: %v", err)
-				continue
-			}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		log.Fatalf("failed to create JetStream context: %v", err)
+	}
+	filestore.RegisterFetcher("natsobj", filecache.NATSObjectFetcher{JS: js})
+	discoverPeers(nc, filestore, opts, peerSubject)
 
-			jsonReq, err := decoder.DecodeAll(compressed, nil)
-			if err != nil {
-				log.Printf("failed to decode zstd message: %v", err)
-				continue
+	if jsMode == nil {
+		_, err = nc.QueueSubscribe(subject, queue, func(m *nats.Msg) {
+			if m.Reply == "" {
+				log.Println("received message without reply subject; skipping")
+				return
 			}
-			decoder.Close()
 
-			// Unmarshal JSON
 			var request api.ExecReq
-			err = json.Unmarshal(jsonReq, &request)
-			if err != nil {
-				log.Printf("failed to unmarshal message: %v", err)
-				_, err = sqsClient.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
-					QueueUrl:      aws.String(submReqQueueUrl),
-					ReceiptHandle: message.ReceiptHandle,
-				})
-				if err != nil {
-					log.Printf("failed to delete message: %v", err)
+			err := decodeRequest(m.Data, &request)
+			if err == nil {
+				if verr := uuid.Validate(request.Uuid); verr != nil {
+					err = fmt.Errorf("invalid uuid %q: %w", request.Uuid, verr)
 				}
-				continue
+			}
+			if err != nil {
+				log.Printf("failed to decode message: %v", err)
+				sendNATSError(nc, m.Reply, "unknown", err.Error())
+				return
 			}
 
 			log.Printf("received request with uuid: %s", request.Uuid)
@@ -180,93 +361,146 @@ func cmdListenSQS() {
 				log.Printf("checker: %s", *request.Checker)
 			}
 
-			gatherer := sqsgath.NewSqsResponseQueueGatherer(request.Uuid, responseQueueUrl)
-			err = t.ExecTests(gatherer, request)
-			if err != nil {
-				log.Printf("Error: %v", err)
-				continue
+			gatherer := natsgath.New(nc, request.Uuid, m.Reply)
+			ctx, cancel := jobContext(opts.jobTimeout)
+			if err := t.ExecTests(ctx, gatherer, request); err != nil {
+				log.Printf("error executing tests: %v", err)
 			}
+			cancel()
+		})
+		if err != nil {
+			log.Fatalf("failed to subscribe: %v", err)
+		}
 
-			_, err = sqsClient.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
-				QueueUrl:      aws.String(submReqQueueUrl),
-				ReceiptHandle: message.ReceiptHandle,
-			})
-			if err != nil {
-				log.Printf("failed to delete message: %v", err)
-			}
+		if err := nc.FlushTimeout(2 * time.Second); err != nil {
+			log.Fatalf("failed to flush NATS connection: %v", err)
 		}
 	}
-}
 
-func cmdListenNATS(natsURL, subject, queue string) {
-	log.Printf("connecting to NATS at %s", redactURL(natsURL))
-	nc, err := nats.Connect(natsURL)
+	// every worker receives prefetch requests, hence no queue group
+	_, err = nc.Subscribe(prefetchSubject, func(m *nats.Msg) {
+		var request api.PrefetchReq
+		if err := decodeRequest(m.Data, &request); err != nil {
+			log.Printf("failed to decode prefetch request: %v", err)
+			if m.Reply != "" {
+				msg := err.Error()
+				b, _ := json.Marshal(api.NewFinishPrefetch("unknown", "", 0, nil, &msg))
+				_ = nc.Publish(m.Reply, b)
+			}
+			return
+		}
+
+		// a prefetch may wait for downloads for up to prefetchTimeout, which
+		// must not hold up the prefetch requests delivered after it
+		go func() {
+			reply := runPrefetch(t, request)
+			if m.Reply == "" {
+				return
+			}
+			b, err := json.Marshal(reply)
+			if err != nil {
+				log.Printf("failed to marshal prefetch reply: %v", err)
+				return
+			}
+			if err := nc.Publish(m.Reply, b); err != nil {
+				log.Printf("failed to publish prefetch reply: %v", err)
+			}
+		}()
+	})
 	if err != nil {
-		log.Fatalf("failed to connect to NATS: %v", err)
+		log.Fatalf("failed to subscribe to prefetch subject: %v", err)
 	}
-	defer nc.Drain()
 
-	t, _, _ := buildTester()
+	if jsMode != nil {
+		consumeJetStream(t, nc, js, subject, *jsMode, opts.jobTimeout)
+		return
+	}
+	log.Printf("worker subscribed subject=%q queue=%q prefetch=%q", subject, queue, prefetchSubject)
+	select {}
+}
 
-	_, err = nc.QueueSubscribe(subject, queue, func(m *nats.Msg) {
-		if m.Reply == "" {
-			log.Println("received message without reply subject; skipping")
-			return
-		}
+// Workers announce their peer URL every peerAnnounceInterval and
+// forget peers that have not been heard from for peerTTL.
+const (
+	peerAnnounceInterval = 30 * time.Second
+	peerTTL              = 3 * peerAnnounceInterval
+	peerSignatureHeader  = "Tester-Peer-Signature" // see filecache.PeerSignature
+)
 
-		// Decode base64
-		compressed, err := base64.StdEncoding.DecodeString(string(m.Data))
-		if err != nil {
-			log.Printf("failed to decode base64 message: %v", err)
-			sendNATSError(nc, m.Reply, "unknown", "bad base64: "+err.Error())
-			return
-		}
+// discoverPeers announces this worker's peer URL on the subject and adds
+// the workers announced by others. Static --peers are kept as they are.
+// With --peer-token, announcements are signed and unsigned ones ignored.
+func discoverPeers(nc *nats.Conn, filestore *filecache.FileStore, opts testerOpts, subject string) {
+	self := advertisedPeerURL(opts)
 
-		// Decompress zstd
-		decoder, err := zstd.NewReader(nil)
-		if err != nil {
-			log.Printf("failed to create zstd decoder: %v", err)
-			sendNATSError(nc, m.Reply, "unknown", "zstd decoder failed: "+err.Error())
+	_, err := nc.Subscribe(subject, func(m *nats.Msg) {
+		peer := string(m.Data)
+		if peer == "" || peer == self {
 			return
 		}
-
-		jsonReq, err := decoder.DecodeAll(compressed, nil)
-		if err != nil {
-			log.Printf("failed to decode zstd message: %v", err)
-			sendNATSError(nc, m.Reply, "unknown", "zstd decode failed: "+err.Error())
-			decoder.Close()
-			return
+		// the peer token is sent to every peer, so only accept the ones that know it
+		if opts.peerToken != "" {
+			sig := filecache.PeerSignature(opts.peerToken, peer)
+			if !hmac.Equal([]byte(m.Header.Get(peerSignatureHeader)), []byte(sig)) {
+				log.Printf("ignoring unauthenticated announcement of peer %s", peer)
+				return
+			}
 		}
-		decoder.Close()
+		filestore.AddPeer(peer, peerTTL)
+	})
+	if err != nil {
+		log.Fatalf("failed to subscribe to peer subject: %v", err)
+	}
 
-		// Unmarshal JSON
-		var request api.ExecReq
-		if err := json.Unmarshal(jsonReq, &request); err != nil {
-			log.Printf("failed to unmarshal message: %v", err)
-			sendNATSError(nc, m.Reply, "unknown", "bad json: "+err.Error())
-			return
+	if self == "" {
+		return // not serving files; only consume the others
+	}
+	go func() {
+		msg := &nats.Msg{Subject: subject, Data: []byte(self), Header: nats.Header{}}
+		if opts.peerToken != "" {
+			msg.Header.Set(peerSignatureHeader, filecache.PeerSignature(opts.peerToken, self))
 		}
-
-		log.Printf("received request with uuid: %s", request.Uuid)
-		if request.Checker != nil {
-			log.Printf("checker: %s", *request.Checker)
+		for {
+			if err := nc.PublishMsg(msg); err != nil {
+				log.Printf("failed to announce peer URL: %v", err)
+			}
+			time.Sleep(peerAnnounceInterval)
 		}
+	}()
+}
 
-		gatherer := natsgath.New(nc, request.Uuid, m.Reply)
-		if err := t.ExecTests(gatherer, request); err != nil {
-			log.Printf("error executing tests: %v", err)
-		}
-	})
+// prefetchTimeout bounds how long a worker keeps waiting for prefetched files
+const prefetchTimeout = 30 * time.Minute
+
+func runPrefetch(t *testerpkg.Tester, request api.PrefetchReq) api.FinishPrefetch {
+	log.Printf("received prefetch request with uuid: %s", request.Uuid)
+	ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+	defer cancel()
+	return t.Prefetch(ctx, request)
+}
+
+// decodeRequest unpacks a base64 encoded, zstd compressed JSON request
+func decodeRequest(data []byte, v any) error {
+	compressed, err := base64.StdEncoding.DecodeString(string(data))
 	if err != nil {
-		log.Fatalf("failed to subscribe: %v", err)
+		return fmt.Errorf("bad base64: %w", err)
 	}
 
-	if err := nc.FlushTimeout(2 * time.Second); err != nil {
-		log.Fatalf("failed to flush NATS connection: %v", err)
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return fmt.Errorf("zstd decoder failed: %w", err)
 	}
+	defer decoder.Close()
 
-	log.Printf("worker subscribed subject=%q queue=%q", subject, queue)
-	select {}
+	jsonReq, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return fmt.Errorf("zstd decode failed: %w", err)
+	}
+
+	if err := json.Unmarshal(jsonReq, v); err != nil {
+		return fmt.Errorf("bad json: %w", err)
+	}
+	return nil
 }
 
 func sendNATSError(nc *nats.Conn, inbox, evalUuid, msg string) {
@@ -275,23 +509,43 @@ func sendNATSError(nc *nats.Conn, inbox, evalUuid, msg string) {
 	_ = nc.Publish(inbox, b)
 }
 
-func cmdVerify(path string, verbose bool, noColor bool) error {
+func cmdVerify(opts testerOpts, path string, verbose bool, noColor bool) error {
 	langs, cases, err := behave.Parse(path)
 	if err != nil {
 		return err
 	}
+	// Configure colors
+	if noColor {
+		color.NoColor = true
+	}
+
+	sb, err := newSandbox(opts.sandbox)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
 	warningCount := 0
-	t, _, _ := buildTester()
+	if opts.sandbox == "isolate" {
+		fmt.Println("=== Environment ===")
+		warnings, failures := printEnvChecks()
+		if failures > 0 {
+			msg := fmt.Sprintf("%d environment check(s) failed", failures)
+			return cli.Exit(msg, 1)
+		}
+		warningCount += warnings
+	} else {
+		color.New(color.FgYellow).Fprintln(os.Stdout, "WARNING")
+		fmt.Printf("%s sandbox provides no isolation; skipping environment checks\n", opts.sandbox)
+		warningCount++
+	}
+
+	t, _ := buildTester(sb, opts)
 	if !verbose {
 		// use a no-op handler to suppress logs
 		t.SetLogger(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})))
 	} else {
 		// default pretty logger already set; nothing to do
 	}
-	// Configure colors
-	if noColor {
-		color.NoColor = true
-	}
 
 	for _, l := range langs {
 		fmt.Printf("=== Language: %s ===\n", l.LangName)
@@ -310,7 +564,7 @@ func cmdVerify(path string, verbose bool, noColor bool) error {
 			continue
 		}
 
-		box, err := isolate.NewBox()
+		box, err := sb.NewBox()
 		if err != nil {
 			msg := "failed to create isolate box"
 			wrapped := fmt.Errorf("%s: %w", msg, err)
@@ -345,7 +599,7 @@ func cmdVerify(path string, verbose bool, noColor bool) error {
 		fmt.Printf("=== Scenario: %s ===\n", c.Name)
 		// Use response builder gatherer to produce a full ExecResponse
 		rb := respbuilder.New(c.Request.Uuid)
-		if err := t.ExecTests(rb, c.Request); err != nil {
+		if err := t.ExecTests(context.TODO(), rb, c.Request); err != nil {
 			return err
 		}
 		response := rb.Response()
@@ -376,9 +630,12 @@ func cmdVerify(path string, verbose bool, noColor bool) error {
 			if res.Subm.RamKiBytes > int64(c.Request.RamKiB) || res.Subm.CgOomKilled {
 				verdict = "MLE"
 				reason = fmt.Sprintf("memory usage %dKiB > %dKiB", res.Subm.RamKiBytes, c.Request.RamKiB)
-			} else if res.Subm.CpuMillis > int64(c.Request.CpuMs) {
+			} else if res.Subm.CpuMillis > int64(c.Request.CpuMs) || res.Subm.Status() == api.IsolateTimedOut {
 				verdict = "TLE"
 				reason = fmt.Sprintf("cpu time %dms > %dms", res.Subm.CpuMillis, c.Request.CpuMs)
+				if res.Subm.CpuMillis <= int64(c.Request.CpuMs) {
+					reason = fmt.Sprintf("wall time %dms exceeded", res.Subm.WallMillis)
+				}
 			} else if res.Subm.ExitCode != 0 || res.Subm.Stderr != "" || res.Subm.ExitSignal != nil {
 				verdict = "RE"
 				if res.Subm.ExitSignal != nil {
@@ -423,12 +680,231 @@ func cmdVerify(path string, verbose bool, noColor bool) error {
 	return nil
 }
 
-func mustEnv(key string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		log.Fatalf("%s environment variable is not set", key)
+// cacheTimeFormat is how cmdCacheLs prints the last use
+const cacheTimeFormat = "2006-01-02 15:04"
+
+// quarantineDir receives cached files that fail cmdCacheVerify
+func quarantineDir() string {
+	return xdg.NewXDGDirs().AppCacheDir("tester/quarantine")
+}
+
+func cmdCacheLs() error {
+	bins, err := testlib.ListCache()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
-	return v
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tSHA256\tSIZE\tON DISK\tLAST USE")
+	for _, f := range openFileStore().List() {
+		size := "?"
+		if f.Logical >= 0 {
+			size = utils.FormatByteSize(f.Logical)
+		}
+		fmt.Fprintf(w, "file\t%s\t%s\t%s\t%s\n", f.Key, size,
+			utils.FormatByteSize(f.Size), f.LastAccess.Format(cacheTimeFormat))
+	}
+	for _, b := range bins {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.Kind, b.Sha256, utils.FormatByteSize(b.Size),
+			utils.FormatByteSize(b.Size), b.LastUse.Format(cacheTimeFormat))
+	}
+	return w.Flush()
+}
+
+func cmdCacheStats() error {
+	bins, err := testlib.ListCache()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	st := openFileStore().Stats()
+	fmt.Printf("files: %d (%d compressed), %s on disk, %s uncompressed\n",
+		st.Files, st.Compressed, utils.FormatByteSize(st.DiskBytes), utils.FormatByteSize(st.LogicalBytes))
+
+	count := make(map[string]int)
+	bytes := make(map[string]int64)
+	for _, b := range bins {
+		count[b.Kind]++
+		bytes[b.Kind] += b.Size
+	}
+	for _, kind := range []string{"checker", "interactor"} {
+		fmt.Printf("%ss: %d, %s on disk\n", kind, count[kind], utils.FormatByteSize(bytes[kind]))
+	}
+	return nil
+}
+
+func cmdCacheGC(maxSize string) error {
+	maxBytes, err := utils.ParseByteSize(maxSize)
+	if err != nil || maxBytes <= 0 {
+		return cli.Exit(fmt.Sprintf("invalid --max-size %q", maxSize), 1)
+	}
+
+	filestore, err := lockFileStore()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	before := filestore.Stats()
+	filestore.SetMaxBytes(maxBytes)
+	if err := filestore.SaveIndex(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	after := filestore.Stats()
+	fmt.Printf("removed %d files, freed %s; %s on disk\n", before.Files-after.Files,
+		utils.FormatByteSize(before.DiskBytes-after.DiskBytes), utils.FormatByteSize(after.DiskBytes))
+	return nil
+}
+
+func cmdCacheVerify() error {
+	dir := quarantineDir()
+	filestore, err := lockFileStore()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	badFiles, err := filestore.Verify(filepath.Join(dir, "files"))
+	for _, key := range badFiles {
+		fmt.Printf("file %s: sha256 mismatch, moved to %s\n", key, dir)
+	}
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if err := filestore.SaveIndex(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	badBins, err := testlib.VerifyCache(dir)
+	for _, name := range badBins {
+		fmt.Printf("%s: source missing or sha256 mismatch, moved to %s\n", name, dir)
+	}
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if n := len(badFiles) + len(badBins); n > 0 {
+		return cli.Exit(fmt.Sprintf("%d cache entries failed verification", n), 1)
+	}
+	fmt.Println("all cache entries are intact")
+	return nil
+}
+
+func cmdCacheRm(keys []string) error {
+	filestore, err := lockFileStore()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	for _, key := range keys {
+		if err := filecache.ValidateKey(key); err != nil {
+			return cli.Exit(fmt.Sprintf("invalid sha256 %s: %v", key, err), 1)
+		}
+
+		found := filestore.Exists(key)
+		if found {
+			if err := filestore.Remove(key); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+		}
+		removedBin, err := testlib.RemoveCached(key)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		if !found && !removedBin {
+			return cli.Exit(fmt.Sprintf("%s is not cached", key), 1)
+		}
+		fmt.Printf("removed %s\n", key)
+	}
+	if err := filestore.SaveIndex(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	return nil
+}
+
+func cmdCacheImport(dir string, compress bool) error {
+	filestore, err := lockFileStore()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	filestore.SetCompression(compress)
+
+	count := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		key, err := filestore.StoreFrom(f)
+		if err != nil {
+			return fmt.Errorf("failed to store %s: %w", path, err)
+		}
+		fmt.Printf("%s  %s\n", key, path)
+		count++
+		return nil
+	})
+	if saveErr := filestore.SaveIndex(); err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	log.Printf("imported %d files from %s", count, dir)
+	return nil
+}
+
+// printEnvChecks runs the sandbox and host environment checks and prints
+// one OK/WARN/FAIL line per check. Returns warning and failure counts.
+func printEnvChecks() (int, int) {
+	cacheDir := xdg.NewXDGDirs().AppCacheDir("tester")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		log.Printf("failed to create cache directory %s: %v", cacheDir, err)
+	}
+
+	results := syscheck.Run(syscheck.Config{
+		ConfigDir:     configDir,
+		CacheDir:      cacheDir,
+		MinFreeBytes:  1 << 30,  // 1 GiB
+		WarnFreeBytes: 10 << 30, // 10 GiB
+	})
+
+	warnings, failures := 0, 0
+	for _, r := range results {
+		var c *color.Color
+		switch r.Level {
+		case syscheck.OK:
+			c = color.New(color.FgGreen)
+		case syscheck.Warn:
+			c = color.New(color.FgYellow)
+			warnings++
+		default:
+			c = color.New(color.FgRed)
+			failures++
+		}
+		c.Fprintf(os.Stdout, "%-4s ", r.Level)
+		fmt.Printf("%-28s %s\n", r.Name, r.Detail)
+	}
+	return warnings, failures
+}
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getAWSRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return "eu-central-1"
 }
 
 func getNATSURL() string {
@@ -451,30 +927,117 @@ func redactURL(rawURL string) string {
 	return u.String()
 }
 
-func buildTester() (*testerpkg.Tester, string, string) {
-	// Initialize XDG directories
-	xdgDirs := xdg.NewXDGDirs()
+// testerOpts are the root flags shared by every command that builds a tester
+type testerOpts struct {
+	sandbox         string
+	ioMode          testerpkg.IOMode
+	cacheMaxSize    string
+	compress        bool
+	workers         int
+	allowHTTP       bool
+	fileRoot        string
+	s3Endpoint      string
+	s3Region        string
+	peerListen      string
+	peerURL         string
+	peers           string
+	peerToken       string
+	archiveDir      string
+	archiveCompress bool
+	outputsBucket   string
+	outputsPrefix   string
+	outputsURL      string
+	jobTimeout      time.Duration
+}
 
-	// Use XDG cache directory for file storage (persistent across restarts)
-	fileDir := xdgDirs.AppCacheDir("tester/files")
-	if err := xdgDirs.EnsureDir(fileDir); err != nil {
-		log.Fatalf("failed to create file storage directory: %v", err)
+func testerOptsFrom(c *cli.Command) testerOpts {
+	return testerOpts{
+		sandbox:         c.String("sandbox"),
+		ioMode:          testerpkg.IOMode(c.String("io-mode")),
+		cacheMaxSize:    c.String("cache-max-size"),
+		compress:        c.Bool("cache-compress"),
+		workers:         c.Int("download-workers"),
+		allowHTTP:       c.Bool("allow-http"),
+		fileRoot:        c.String("file-root"),
+		s3Endpoint:      c.String("s3-endpoint"),
+		s3Region:        c.String("s3-region"),
+		peerListen:      c.String("peer-listen"),
+		peerURL:         c.String("peer-url"),
+		peers:           c.String("peers"),
+		peerToken:       c.String("peer-token"),
+		archiveDir:      c.String("archive-dir"),
+		archiveCompress: c.Bool("archive-compress"),
+		outputsBucket:   c.String("outputs-bucket"),
+		outputsPrefix:   c.String("outputs-prefix"),
+		outputsURL:      c.String("outputs-url"),
+		jobTimeout:      c.Duration("job-timeout"),
 	}
+}
 
-	// Use XDG runtime directory for temporary files (cleaned on logout/reboot)
-	tmpDir := xdgDirs.AppRuntimeDir("tester")
-	if err := xdgDirs.EnsureRuntimeDir(tmpDir); err != nil {
-		log.Fatalf("failed to create tmp directory: %v", err)
+// jobContext bounds a job received by a listener to --job-timeout.
+// Jobs are not cancelled on shutdown, so that the worker can drain them.
+func jobContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
 	}
+	cause := fmt.Errorf("job did not finish within --job-timeout %s", timeout)
+	return context.WithTimeoutCause(context.Background(), timeout, cause)
+}
 
-	filestore := filecache.New(fileDir, tmpDir)
-	go filestore.Start()
+// newSandbox picks the sandbox backend by its --sandbox flag value.
+func newSandbox(name string) (sandbox.Sandbox, error) {
+	switch name {
+	case "isolate":
+		return isolate.GetInstance(), nil
+	case "fake":
+		dir := xdg.NewXDGDirs().AppRuntimeDir("tester/fake")
+		return fake.New(dir)
+	default:
+		return nil, fmt.Errorf("unknown sandbox %q; expected isolate or fake", name)
+	}
+}
 
-	tlibCompiler := testlib.NewTestlibCompiler()
+func mustSandbox(name string) sandbox.Sandbox {
+	sb, err := newSandbox(name)
+	if err != nil {
+		log.Fatalf("failed to create sandbox: %v", err)
+	}
+	if name != "isolate" {
+		log.Printf("WARNING: %s sandbox provides no isolation; never use it in production", name)
+	}
+	return sb
+}
 
-	// Read configuration assets from /usr/local/etc/tester
-	configDir := "/usr/local/etc/tester"
+func buildTester(sb sandbox.Sandbox, opts testerOpts) (*testerpkg.Tester, *filecache.FileStore) {
+	if opts.ioMode != testerpkg.IOFiles && opts.ioMode != testerpkg.IOPipes {
+		log.Fatalf("unknown io mode %q; expected files or pipes", opts.ioMode)
+	}
 
+	cacheMaxBytes, err := utils.ParseByteSize(opts.cacheMaxSize)
+	if err != nil {
+		log.Fatalf("invalid --cache-max-size: %v", err)
+	}
+
+	filestore := openFileStore()
+	if err := filestore.Claim(); err != nil {
+		if errors.Is(err, filecache.ErrInUse) {
+			log.Fatalf("%v; run one worker per cache, or give each its own XDG_CACHE_HOME", err)
+		}
+		log.Fatalf("failed to claim the file cache: %v", err)
+	}
+	filestore.SetMaxBytes(cacheMaxBytes)
+	filestore.SetWorkers(opts.workers)
+	filestore.SetCompression(opts.compress)
+	st := filestore.Stats()
+	log.Printf("file cache holds %d files (%d compressed): %d MiB on disk, %d MiB uncompressed",
+		st.Files, st.Compressed, st.DiskBytes>>20, st.LogicalBytes>>20)
+	registerFetchers(filestore, opts)
+	startPeers(filestore, opts)
+	go filestore.Start()
+
+	tlibCompiler := testlib.NewTestlibCompiler(sb)
+
+	// Read configuration assets from configDir
 	readFileIfExists := func(path string) (string, error) {
 		data, err := os.ReadFile(path)
 		if err != nil {
@@ -502,6 +1065,162 @@ func buildTester() (*testerpkg.Tester, string, string) {
 		log.Printf("testlib.h not found or empty in %s; checker/interactor compilation may fail", configDir)
 	}
 
-	t := testerpkg.NewTester(filestore, tlibCompiler, systemInfoTxt, testlibHStr)
-	return t, systemInfoTxt, testlibHStr
+	t := testerpkg.NewTester(sb, filestore, tlibCompiler, systemInfoTxt, testlibHStr)
+	t.SetIOMode(opts.ioMode)
+	if opts.archiveDir != "" {
+		archive, err := archivegath.Open(opts.archiveDir, opts.archiveCompress)
+		if err != nil {
+			log.Fatalf("failed to open --archive-dir: %v", err)
+		}
+		t.AddObserver("archive", func(req api.ExecReq) internal.ResultGatherer {
+			return archivegath.New(archive, req)
+		})
+		log.Printf("archiving results of every job in %s", opts.archiveDir)
+	}
+	if opts.outputsBucket != "" {
+		cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(opts.s3Region))
+		if err != nil {
+			log.Fatalf("unable to load SDK config for --outputs-bucket: %v", err)
+		}
+		client, err := newS3Client(cfg, opts.s3Endpoint)
+		if err != nil {
+			log.Fatalf("invalid --s3-endpoint: %v", err)
+		}
+		store, err := uploadgath.NewStore(client, opts.outputsBucket, opts.outputsPrefix, opts.outputsURL)
+		if err != nil {
+			log.Fatalf("invalid --outputs-bucket: %v", err)
+		}
+		t.SetOutputStore(store)
+		log.Printf("uploading truncated outputs to s3://%s/%s", opts.outputsBucket, opts.outputsPrefix)
+	}
+	return t, filestore
+}
+
+// startPeers serves the file cache to other workers on --peer-listen
+// and adds the static --peers.
+func startPeers(filestore *filecache.FileStore, opts testerOpts) {
+	filestore.SetPeerToken(opts.peerToken)
+	for _, peer := range splitList(opts.peers) {
+		filestore.AddPeer(peer, 0)
+	}
+
+	if opts.peerListen == "" {
+		return
+	}
+	if opts.peerToken == "" {
+		log.Printf("WARNING: serving cached test files on %s without --peer-token", opts.peerListen)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(filecache.PeerPath, filestore.PeerHandler())
+	server := &http.Server{
+		Addr:              opts.peerListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("serving cached test files to peers on %s as %s", opts.peerListen, advertisedPeerURL(opts))
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("failed to serve peers: %v", err)
+		}
+	}()
+}
+
+// advertisedPeerURL is the URL other workers reach this one at,
+// empty if it does not serve files
+func advertisedPeerURL(opts testerOpts) string {
+	if opts.peerListen == "" {
+		return ""
+	}
+	if opts.peerURL != "" {
+		return strings.TrimSuffix(opts.peerURL, "/")
+	}
+	host, port, err := net.SplitHostPort(opts.peerListen)
+	if err != nil {
+		log.Fatalf("invalid --peer-listen: %v", err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, err = os.Hostname()
+		if err != nil {
+			log.Fatalf("failed to get hostname for --peer-url: %v", err)
+		}
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// openFileStore opens the test file cache in the XDG directories.
+// Downloads do not run until Start is called.
+func openFileStore() *filecache.FileStore {
+	// Initialize XDG directories
+	xdgDirs := xdg.NewXDGDirs()
+
+	// Use XDG cache directory for file storage (persistent across restarts)
+	fileDir := xdgDirs.AppCacheDir("tester/files")
+	if err := xdgDirs.EnsureDir(fileDir); err != nil {
+		log.Fatalf("failed to create file storage directory: %v", err)
+	}
+
+	// Use XDG runtime directory for temporary files (cleaned on logout/reboot)
+	tmpDir := xdgDirs.AppRuntimeDir("tester")
+	if err := xdgDirs.EnsureRuntimeDir(tmpDir); err != nil {
+		log.Fatalf("failed to create tmp directory: %v", err)
+	}
+
+	return filecache.New(fileDir, tmpDir)
+}
+
+// lockFileStore opens the file cache for the cache commands that change it.
+// It fails while a worker uses the cache rather than racing with its jobs.
+func lockFileStore() (*filecache.FileStore, error) {
+	filestore := openFileStore()
+	if err := filestore.Lock(); err != nil {
+		if errors.Is(err, filecache.ErrInUse) {
+			return nil, fmt.Errorf("%w; stop it before changing the cache", err)
+		}
+		return nil, err
+	}
+	return filestore, nil
+}
+
+// registerFetchers enables the test URL schemes selected by the root flags.
+// https is always accepted; natsobj:// is added by the NATS listener.
+func registerFetchers(filestore *filecache.FileStore, opts testerOpts) {
+	if opts.allowHTTP {
+		log.Printf("WARNING: plain http test URLs are accepted; never use --allow-http in production")
+		filestore.RegisterFetcher("http", filecache.HTTPFetcher{})
+	}
+
+	if opts.fileRoot != "" {
+		filestore.RegisterFetcher("file", filecache.FileFetcher{Root: opts.fileRoot})
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(opts.s3Region))
+	if err != nil {
+		log.Printf("s3:// test URLs are disabled; unable to load SDK config: %v", err)
+		return
+	}
+	client, err := newS3Client(cfg, opts.s3Endpoint)
+	if err != nil {
+		log.Fatalf("invalid --s3-endpoint: %v", err)
+	}
+	filestore.RegisterFetcher("s3", filecache.S3Fetcher{Client: client})
+}
+
+// newS3Client creates a client of AWS S3, or of the S3-compatible endpoint
+// if set. Custom endpoints are addressed path-style, so that local ones
+// like MinIO work without virtual-host DNS.
+func newS3Client(cfg aws.Config, endpoint string) (*s3.Client, error) {
+	if endpoint == "" {
+		return s3.NewFromConfig(cfg), nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid scheme %q; expected http or https", u.Scheme)
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	}), nil
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal/gatherer/multigath"
+	"github.com/programme-lv/tester/internal/gatherer/respbuilder"
+	"github.com/programme-lv/tester/internal/gatherer/streamgath"
+	"github.com/programme-lv/tester/internal/spool"
+	testerpkg "github.com/programme-lv/tester/internal/tester"
+)
+
+// Outputs of a finished spool job, written as <name>.<suffix>
+const (
+	spoolResponseSuffix = "response.json"
+	spoolEventsSuffix   = "events.jsonl"
+	spoolErrorSuffix    = "error.txt"
+)
+
+// cmdListenDir runs jobs from the spool directory root, polling its
+// incoming/ directory every poll interval while there is nothing to do.
+func cmdListenDir(opts testerOpts, root string, poll time.Duration) {
+	s, err := spool.Open(root)
+	if err != nil {
+		log.Fatalf("failed to open spool: %v", err)
+	}
+	if n, err := s.Recover(); err != nil {
+		log.Printf("failed to recover stale jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("returned %d jobs of stopped workers to %s", n, spool.IncomingDir)
+	}
+
+	t, _ := buildTester(mustSandbox(opts.sandbox), opts)
+
+	log.Printf("worker watching spool %s every %v", root, poll)
+	for {
+		job, err := s.Claim()
+		if err != nil {
+			log.Printf("failed to claim job: %v", err)
+		}
+		if job == nil {
+			time.Sleep(poll)
+			continue
+		}
+		runSpoolJob(t, job, opts.jobTimeout)
+	}
+}
+
+// runSpoolJob runs a claimed job and moves it to done/, or to failed/
+// if it could not be decoded or finished with an internal error
+func runSpoolJob(t *testerpkg.Tester, job *spool.Job, jobTimeout time.Duration) {
+	request, err := readSpoolRequest(job)
+	if err != nil {
+		log.Printf("failed to read job %s: %v", job.Name, err)
+		if err := job.Fail(map[string][]byte{spoolErrorSuffix: []byte(err.Error() + "\n")}); err != nil {
+			log.Printf("failed to move job %s: %v", job.Name, err)
+		}
+		return
+	}
+
+	log.Printf("received spool job %s with uuid: %s", job.Name, request.Uuid)
+	var events bytes.Buffer
+	builder := respbuilder.New(request.Uuid)
+	recorder := streamgath.New(request.Uuid, func(msgType api.MsgType, msg any) {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("failed to marshal message: %v", err)
+			return
+		}
+		events.Write(b)
+		events.WriteByte('\n')
+	})
+	gatherer := multigath.New(
+		multigath.Sink{Name: "response", Gatherer: builder},
+		multigath.Sink{Name: "events", Gatherer: recorder},
+	)
+	ctx, cancel := jobContext(jobTimeout)
+	if err := t.ExecTests(ctx, gatherer, request); err != nil {
+		log.Printf("error executing tests: %v", err)
+	}
+	cancel()
+	gatherer.Close()
+
+	response := builder.Response()
+	b, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		log.Printf("failed to marshal response of %s: %v", job.Name, err)
+		return // left in processing/ for the operator
+	}
+	outputs := map[string][]byte{
+		spoolResponseSuffix: b,
+		spoolEventsSuffix:   events.Bytes(),
+	}
+
+	finish := job.Done
+	if response.Status == api.InternalError {
+		finish = job.Fail
+	}
+	if err := finish(outputs); err != nil {
+		log.Printf("failed to move job %s: %v", job.Name, err)
+	}
+}
+
+func readSpoolRequest(job *spool.Job) (api.ExecReq, error) {
+	var request api.ExecReq
+	data, err := job.Read()
+	if err != nil {
+		return request, err
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return request, fmt.Errorf("bad json: %w", err)
+	}
+	if request.Uuid == "" {
+		request.Uuid = uuid.New().String()
+	}
+	if err := uuid.Validate(request.Uuid); err != nil {
+		return request, fmt.Errorf("invalid uuid %q: %w", request.Uuid, err)
+	}
+	return request, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal/gatherer/sqsgath"
+	testerpkg "github.com/programme-lv/tester/internal/tester"
+)
+
+// sqsOpts configures `tester listen sqs`
+type sqsOpts struct {
+	queueURL    string // jobs and prefetch requests
+	responseURL string // receives the stream messages of the jobs
+	dlqURL      string // receives poison jobs; none if empty
+	endpoint    string // SQS-compatible endpoint; AWS if empty
+	region      string
+	concurrency int           // jobs run at once
+	visibility  time.Duration // redelivery delay of a job whose worker stopped
+	maxReceives int           // receives of a job before it is dead-lettered
+}
+
+// SQS message attribute that tells prefetch requests apart from jobs
+const (
+	jobTypeAttr     = "job_type"
+	jobTypePrefetch = "prefetch"
+)
+
+// Attributes of dead-lettered jobs
+const (
+	dlqErrorAttr        = "error"
+	dlqReceiveCountAttr = "receive_count"
+)
+
+// sqsMaxMessages is the most messages a single receive may return
+const sqsMaxMessages = 10
+
+func cmdListenSQS(opts testerOpts, q sqsOpts) {
+	if q.queueURL == "" || q.responseURL == "" {
+		log.Fatalf("--queue-url and --response-url are required (env: SUBM_REQ_QUEUE_URL, RESPONSE_QUEUE_URL)")
+	}
+	if q.visibility < 3*time.Second {
+		log.Fatalf("--visibility-timeout must be at least 3s, got %v", q.visibility)
+	}
+	if q.concurrency < 1 {
+		q.concurrency = 1
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(q.region))
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if q.endpoint != "" {
+			o.BaseEndpoint = aws.String(q.endpoint)
+		}
+	})
+	t, _ := buildTester(mustSandbox(opts.sandbox), opts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	l := &sqsListener{t: t, client: client, opts: q, jobTimeout: opts.jobTimeout, slots: make(chan struct{}, q.concurrency)}
+	log.Printf("worker receiving from %s with concurrency %d", q.queueURL, q.concurrency)
+	l.receive(ctx)
+	stop() // a second signal exits at once
+
+	log.Printf("shutting down; waiting for %d running jobs", len(l.slots))
+	l.wg.Wait()
+	log.Printf("all jobs finished")
+}
+
+type sqsListener struct {
+	t          *testerpkg.Tester
+	client     *sqs.Client
+	opts       sqsOpts
+	jobTimeout time.Duration
+	slots      chan struct{} // one per running job
+	wg         sync.WaitGroup
+}
+
+// receive starts jobs until ctx is done. A message is only received when
+// a slot is free for it, so that none waits while its visibility timeout runs.
+func (l *sqsListener) receive(ctx context.Context) {
+	for {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		reserved := 1
+	reserve:
+		for reserved < sqsMaxMessages {
+			select {
+			case l.slots <- struct{}{}:
+				reserved++
+			default:
+				break reserve
+			}
+		}
+
+		output, err := l.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(l.opts.queueURL),
+			MaxNumberOfMessages:         int32(reserved),
+			WaitTimeSeconds:             5,
+			VisibilityTimeout:           int32(l.opts.visibility / time.Second),
+			MessageAttributeNames:       []string{jobTypeAttr},
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+		})
+		if err != nil {
+			l.release(reserved)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("failed to receive messages, %v", err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		for _, message := range output.Messages {
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				defer l.release(1)
+				l.handle(message)
+			}()
+		}
+		l.release(reserved - len(output.Messages))
+	}
+}
+
+func (l *sqsListener) release(n int) {
+	for range n {
+		<-l.slots
+	}
+}
+
+func (l *sqsListener) handle(message types.Message) {
+	if attr, ok := message.MessageAttributes[jobTypeAttr]; ok &&
+		aws.ToString(attr.StringValue) == jobTypePrefetch {
+		var request api.PrefetchReq
+		if err := decodeRequest([]byte(aws.ToString(message.Body)), &request); err != nil {
+			log.Printf("failed to decode prefetch request: %v", err)
+		} else {
+			// a cache warm-up is not worth redelivering or a job slot; reply when done
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				reply := runPrefetch(l.t, request)
+				sendSQSMessage(l.client, l.opts.responseURL, reply)
+			}()
+		}
+		l.delete(message)
+		return
+	}
+
+	var request api.ExecReq
+	if err := decodeRequest([]byte(aws.ToString(message.Body)), &request); err != nil {
+		log.Printf("failed to decode job: %v", err)
+		l.deadLetter(message, err.Error())
+		return
+	}
+
+	received := receiveCount(message)
+	if l.opts.maxReceives > 0 && received > l.opts.maxReceives {
+		// its workers kept stopping before it finished
+		reason := fmt.Sprintf("not finished after %d receives", received-1)
+		log.Printf("job %s was %s", request.Uuid, reason)
+		l.deadLetter(message, reason)
+		sendSQSMessage(l.client, l.opts.responseURL, api.NewFinishJob(request.Uuid, &reason, false, true))
+		return
+	}
+
+	log.Printf("received request with uuid: %s (receive %d of %d)", request.Uuid, received, l.opts.maxReceives)
+	stop := l.heartbeat(message)
+	gatherer := sqsgath.NewSqsResponseQueueGatherer(l.client, request.Uuid, l.opts.responseURL)
+	ctx, cancel := jobContext(l.jobTimeout)
+	if err := l.t.ExecTests(ctx, gatherer, request); err != nil {
+		log.Printf("error executing tests: %v", err)
+	}
+	cancel()
+	stop()
+
+	// FinishJob has been sent; the job has its answer either way
+	l.delete(message)
+}
+
+// heartbeat extends the visibility timeout of a running job,
+// so that it is not received by another worker
+func (l *sqsListener) heartbeat(message types.Message) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(l.opts.visibility / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := l.client.ChangeMessageVisibility(context.TODO(), &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          aws.String(l.opts.queueURL),
+					ReceiptHandle:     message.ReceiptHandle,
+					VisibilityTimeout: int32(l.opts.visibility / time.Second),
+				})
+				if err != nil {
+					log.Printf("failed to extend job visibility: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// deadLetter moves a poison job to --dlq-url with the reason in its
+// attributes. Without a dead-letter queue the job is dropped.
+func (l *sqsListener) deadLetter(message types.Message, reason string) {
+	if l.opts.dlqURL == "" {
+		log.Printf("dropping message %s: no --dlq-url", aws.ToString(message.MessageId))
+		l.delete(message)
+		return
+	}
+
+	_, err := l.client.SendMessage(context.TODO(), &sqs.SendMessageInput{
+		QueueUrl:    aws.String(l.opts.dlqURL),
+		MessageBody: message.Body,
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			dlqErrorAttr: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(reason),
+			},
+			dlqReceiveCountAttr: {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(receiveCount(message))),
+			},
+		},
+	})
+	if err != nil {
+		// kept in the queue; retried once its visibility timeout ends
+		log.Printf("failed to dead-letter message %s: %v", aws.ToString(message.MessageId), err)
+		return
+	}
+	l.delete(message)
+}
+
+func (l *sqsListener) delete(message types.Message) {
+	_, err := l.client.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(l.opts.queueURL),
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		log.Printf("failed to delete message: %v", err)
+	}
+}
+
+// receiveCount is how many times the message has been received, including now
+func receiveCount(message types.Message) int {
+	n, err := strconv.Atoi(message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+func sendSQSMessage(sqsClient *sqs.Client, queueUrl string, msg any) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to marshal message: %v", err)
+		return
+	}
+	_, err = sqsClient.SendMessage(context.TODO(), &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueUrl),
+		MessageBody: aws.String(string(b)),
+	})
+	if err != nil {
+		log.Printf("failed to send message: %v", err)
+	}
+}
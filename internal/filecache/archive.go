@@ -0,0 +1,315 @@
+package filecache
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Limits on what is unpacked from one archive, so that a small
+// archive cannot expand into more files or bytes than the cache holds
+const (
+	maxArchiveEntries    = 100_000
+	maxArchiveEntryBytes = 1 << 30 // per file
+	maxArchiveBytes      = 4 << 30 // all files together
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// AwaitArchive waits for a scheduled tar, tar.zst, tar.gz or zip archive
+// and unpacks its regular files into the store. Returns the cache key of
+// every file by its cleaned in-archive path, e.g. "tests/001.in".
+// The returned files are pinned; the caller must Unpin every value of the
+// index. An archive is unpacked once; later calls reuse the index
+// as long as all of its files are still cached.
+func (fs *FileStore) AwaitArchive(ctx context.Context, key string) (map[string]string, error) {
+	if err := fs.waitFor(ctx, key); err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	index, ok := fs.archives[key]
+	fs.mu.Unlock()
+	if ok && fs.pinIndex(index) {
+		return index, nil
+	}
+
+	// the files are pinned by the unpacking call until it returns, which
+	// may be too short for the callers sharing it, so they retry once
+	for attempt := 1; ; attempt++ {
+		ran := false
+		v, err, _ := fs.unpacking.Do(key, func() (any, error) {
+			ran = true
+			return fs.unpack(key)
+		})
+		if err != nil {
+			errMsg := "failed to unpack archive %s: %w"
+			return nil, fmt.Errorf(errMsg, key, err)
+		}
+		u := v.(*unpacked)
+
+		fs.mu.Lock()
+		fs.archives[key] = u.index
+		fs.mu.Unlock()
+
+		ok := fs.pinIndex(u.index)
+		if ran {
+			fs.Unpin(u.pinned...)
+		}
+		if ok {
+			return u.index, nil
+		}
+		if attempt == 2 {
+			errMsg := "files of archive %s were evicted while it was unpacked"
+			return nil, fmt.Errorf(errMsg, key)
+		}
+	}
+}
+
+// pinIndex pins the files of an archive index if all of them are cached
+func (fs *FileStore) pinIndex(index map[string]string) bool {
+	keys := make([]string, 0, len(index))
+	for _, key := range index {
+		keys = append(keys, key)
+	}
+	fs.Pin(keys...)
+	for _, key := range keys {
+		if !fs.Exists(key) {
+			fs.Unpin(keys...)
+			return false
+		}
+	}
+	return true
+}
+
+// unpacked is the result of unpacking an archive. Its files are pinned
+// while the archive is being unpacked, so that storing later entries
+// cannot evict earlier ones.
+type unpacked struct {
+	index  map[string]string
+	pinned []string
+	bytes  int64
+}
+
+func (fs *FileStore) unpack(key string) (*unpacked, error) {
+	log.Printf("Unpacking archive %s", key)
+
+	r, err := fs.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	u := &unpacked{index: make(map[string]string)}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		err = fs.unpackZip(u, key, br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		d, derr := zstd.NewReader(br)
+		if derr != nil {
+			errMsg := "failed to create zstd reader: %w"
+			return nil, fmt.Errorf(errMsg, derr)
+		}
+		defer d.Close()
+		err = fs.unpackTar(u, d)
+	case bytes.HasPrefix(magic, gzipMagic):
+		g, gerr := gzip.NewReader(br)
+		if gerr != nil {
+			errMsg := "failed to create gzip reader: %w"
+			return nil, fmt.Errorf(errMsg, gerr)
+		}
+		defer g.Close()
+		err = fs.unpackTar(u, g)
+	default:
+		err = fs.unpackTar(u, br)
+	}
+	if err != nil {
+		fs.Unpin(u.pinned...)
+		return nil, err
+	}
+	return u, nil
+}
+
+func (fs *FileStore) unpackTar(u *unpacked, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			errMsg := "failed to read tar entry: %w"
+			return fmt.Errorf(errMsg, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fs.addArchiveEntry(u, hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// unpackZip needs random access, which a stream over a compressed
+// store file does not offer, so such archives are spooled to tmpDir first.
+func (fs *FileStore) unpackZip(u *unpacked, key string, r io.Reader) error {
+	zipPath, ok := fs.RawPath(key)
+	if !ok {
+		tmp, err := os.Create(filepath.Join(fs.tmpDir, uuid.New().String()))
+		if err != nil {
+			errMsg := "failed to create temp file: %w"
+			return fmt.Errorf(errMsg, err)
+		}
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+		if _, err := io.Copy(tmp, r); err != nil {
+			errMsg := "failed to spool zip archive to %s: %w"
+			return fmt.Errorf(errMsg, tmp.Name(), err)
+		}
+		zipPath = tmp.Name()
+	}
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		errMsg := "failed to open zip archive: %w"
+		return fmt.Errorf(errMsg, err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			errMsg := "failed to open zip entry %s: %w"
+			return fmt.Errorf(errMsg, f.Name, err)
+		}
+		err = fs.addArchiveEntry(u, f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addArchiveEntry stores and pins an entry. The size limits are enforced
+// on the bytes actually read, as archive headers may understate them.
+func (fs *FileStore) addArchiveEntry(u *unpacked, name string, r io.Reader) error {
+	if len(u.pinned) >= maxArchiveEntries {
+		errMsg := "archive has more than %d files"
+		return fmt.Errorf(errMsg, maxArchiveEntries)
+	}
+	limit := min(int64(maxArchiveEntryBytes), maxArchiveBytes-u.bytes)
+	key, size, err := fs.storeFrom(r, limit, true)
+	if errors.Is(err, errTooLarge) && limit < maxArchiveEntryBytes {
+		errMsg := "archive unpacks to more than %d bytes"
+		return fmt.Errorf(errMsg, maxArchiveBytes)
+	}
+	if err != nil {
+		errMsg := "failed to store archive entry %s: %w"
+		return fmt.Errorf(errMsg, name, err)
+	}
+	u.pinned = append(u.pinned, key)
+	u.bytes += size
+	u.index[CleanArchivePath(name)] = key
+	return nil
+}
+
+// CleanArchivePath normalises in-archive paths, so that "./a/b" and "a/b"
+// refer to the same file
+func CleanArchivePath(name string) string {
+	p := path.Clean("/" + filepath.ToSlash(name))
+	return p[1:]
+}
+
+// StoreFrom stores the contents of r under their sha256 and returns the key.
+// Unlike Store it streams, so large files are never held in memory.
+func (fs *FileStore) StoreFrom(r io.Reader) (string, error) {
+	key, _, err := fs.storeFrom(r, -1, false)
+	return key, err
+}
+
+var errTooLarge = errors.New("file exceeds the size limit")
+
+// storeFrom implements StoreFrom. Contents longer than limit are not stored
+// unless limit is negative. If pin is set, the file is pinned before it
+// becomes evictable; the caller must Unpin it.
+func (fs *FileStore) storeFrom(r io.Reader, limit int64, pin bool) (string, int64, error) {
+	tmpFile, err := os.Create(filepath.Join(fs.tmpDir, uuid.New().String()))
+	if err != nil {
+		errMsg := "failed to create temp file: %w"
+		return "", 0, fmt.Errorf(errMsg, err)
+	}
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+
+	if limit >= 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hasher), r)
+	if err != nil {
+		errMsg := "failed to write temp file %s: %w"
+		return "", 0, fmt.Errorf(errMsg, tmpFile.Name(), err)
+	}
+	if limit >= 0 && size > limit {
+		errMsg := "%w of %d bytes"
+		return "", 0, fmt.Errorf(errMsg, errTooLarge, limit)
+	}
+	key := hex.EncodeToString(hasher.Sum(nil))
+	if pin {
+		fs.Pin(key)
+	}
+	if fs.Exists(key) {
+		fs.touch(key)
+		return key, size, nil
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		if pin {
+			fs.Unpin(key)
+		}
+		errMsg := "failed to sync temp file %s: %w"
+		return "", 0, fmt.Errorf(errMsg, tmpFile.Name(), err)
+	}
+	saveToPath := fs.path(key)
+	compress := fs.compression()
+	if compress {
+		saveToPath = fs.zstPath(key)
+	}
+	if err := commitFile(tmpFile, size, fs.tmpDir, saveToPath, compress); err != nil {
+		if pin {
+			fs.Unpin(key)
+		}
+		return "", 0, err
+	}
+	fs.added(key, nil)
+	return key, size, nil
+}
@@ -0,0 +1,191 @@
+package filecache
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testArchiveFiles are the regular files of the test archives, by the
+// names they are stored under
+var testArchiveFiles = map[string]string{
+	"./tests/001.in": "1 2\n",
+	"tests/001.ans":  "3\n",
+	"/checker.cpp":   "int main() {}\n",
+}
+
+func testTar(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	// directories and links are skipped
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "tests/", Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "tests/link", Linkname: "/etc/passwd"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range testArchiveFiles {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create("tests/"); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range testArchiveFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAwaitArchive(t *testing.T) {
+	tarData := testTar(t)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(tarData)
+	gw.Close()
+	enc, _ := zstd.NewWriter(nil)
+	zst := enc.EncodeAll(tarData, nil)
+	enc.Close()
+
+	archives := map[string][]byte{
+		"tar":     tarData,
+		"tar.gz":  gz.Bytes(),
+		"tar.zst": zst,
+		"zip":     testZip(t),
+	}
+	for format, data := range archives {
+		for _, compress := range []bool{false, true} {
+			fs := newTestStore(t)
+			fs.SetCompression(compress)
+			key, err := fs.Store(data)
+			if err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			// a budget this small evicts every file that is not pinned;
+			// the archive itself is kept to check what is evicted below
+			fs.Pin(key)
+			fs.SetMaxBytes(1)
+
+			index, err := fs.AwaitArchive(context.Background(), key)
+			if err != nil {
+				t.Fatalf("%s, compress=%v: AwaitArchive: %v", format, compress, err)
+			}
+			if len(index) != len(testArchiveFiles) {
+				t.Errorf("%s, compress=%v: index = %v, want %d files", format, compress, index, len(testArchiveFiles))
+			}
+			for name, want := range testArchiveFiles {
+				fileKey, ok := index[CleanArchivePath(name)]
+				if !ok {
+					t.Errorf("%s, compress=%v: %s is missing from the index", format, compress, name)
+					continue
+				}
+				if got, err := fs.Get(fileKey); err != nil || string(got) != want {
+					t.Errorf("%s, compress=%v: %s = %q, %v; want %q", format, compress, name, got, err, want)
+				}
+			}
+
+			// once released, the files go over the budget
+			for _, fileKey := range index {
+				fs.Unpin(fileKey)
+			}
+			fs.SetMaxBytes(1)
+			for name, fileKey := range index {
+				if fs.Exists(fileKey) {
+					t.Errorf("%s, compress=%v: %s is still pinned", format, compress, name)
+				}
+			}
+			if !fs.Exists(key) {
+				t.Errorf("%s, compress=%v: the pinned archive was evicted", format, compress)
+			}
+		}
+	}
+}
+
+func TestAwaitArchiveReusesIndex(t *testing.T) {
+	fs := newTestStore(t)
+	key, err := fs.Store(testTar(t))
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	first, err := fs.AwaitArchive(context.Background(), key)
+	if err != nil {
+		t.Fatalf("AwaitArchive: %v", err)
+	}
+	second, err := fs.AwaitArchive(context.Background(), key)
+	if err != nil {
+		t.Fatalf("AwaitArchive again: %v", err)
+	}
+	// both callers hold their own pins
+	for name, fileKey := range second {
+		if first[name] != fileKey {
+			t.Errorf("%s: %s, then %s", name, first[name], fileKey)
+		}
+		fs.mu.Lock()
+		pins := fs.pins[fileKey]
+		fs.mu.Unlock()
+		if pins != 2 {
+			t.Errorf("%s is pinned %d times, want 2", name, pins)
+		}
+	}
+}
+
+func TestAddArchiveEntryLimits(t *testing.T) {
+	fs := newTestStore(t)
+
+	full := &unpacked{index: make(map[string]string), pinned: make([]string, maxArchiveEntries)}
+	err := fs.addArchiveEntry(full, "one-too-many", strings.NewReader("x"))
+	if err == nil || !strings.Contains(err.Error(), "more than 100000 files") {
+		t.Errorf("entry over the file limit: %v", err)
+	}
+
+	// the limit applies to the bytes read, whatever the header claimed
+	nearlyFull := &unpacked{index: make(map[string]string), bytes: maxArchiveBytes - 3}
+	err = fs.addArchiveEntry(nearlyFull, "big", strings.NewReader("12345"))
+	if err == nil || !strings.Contains(err.Error(), "unpacks to more than") {
+		t.Errorf("entry over the archive limit: %v", err)
+	}
+	if entries, _ := os.ReadDir(fs.tmpDir); len(entries) != 0 || len(fs.List()) != 0 {
+		t.Errorf("rejected entries left %d temp files and %d stored files", len(entries), len(fs.List()))
+	}
+
+	if err := fs.addArchiveEntry(nearlyFull, "./small", strings.NewReader("123")); err != nil {
+		t.Fatalf("entry within the limit: %v", err)
+	}
+	if nearlyFull.bytes != maxArchiveBytes || nearlyFull.index["small"] == "" || len(nearlyFull.pinned) != 1 {
+		t.Errorf("after storing the entry: %d bytes, index %v, pinned %v",
+			nearlyFull.bytes, nearlyFull.index, nearlyFull.pinned)
+	}
+	fs.Unpin(nearlyFull.pinned...)
+}
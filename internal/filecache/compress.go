@@ -0,0 +1,202 @@
+package filecache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstExt marks files kept zstd compressed at rest. They are still keyed
+// by the sha256 of the uncompressed contents.
+const zstExt = ".zst"
+
+// SetCompression makes the store keep files it writes from now on zstd
+// compressed on disk. Files already stored are left as they are.
+// Compressed files cannot be hardlinked into boxes; see RawPath.
+func (fs *FileStore) SetCompression(enabled bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.compress = enabled
+}
+
+func (fs *FileStore) compression() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.compress
+}
+
+func (fs *FileStore) zstPath(key string) string {
+	return fs.path(key) + zstExt
+}
+
+// storedPath returns where the file lies on disk and whether it is compressed
+func (fs *FileStore) storedPath(key string) (string, bool, error) {
+	if _, err := os.Stat(fs.path(key)); err == nil {
+		return fs.path(key), false, nil
+	}
+	if _, err := os.Stat(fs.zstPath(key)); err == nil {
+		return fs.zstPath(key), true, nil
+	}
+	errMsg := "file %s is not in the store"
+	return "", false, fmt.Errorf(errMsg, key)
+}
+
+// RawPath returns the path of an uncompressed stored file. Such a file
+// can be hardlinked and must be treated as read-only; it is shared by all jobs.
+// False if the file is missing or compressed, in which case use Open.
+func (fs *FileStore) RawPath(key string) (string, bool) {
+	path, compressed, err := fs.storedPath(key)
+	if err != nil || compressed {
+		return "", false
+	}
+	return path, true
+}
+
+// Open streams the uncompressed contents of a stored file.
+func (fs *FileStore) Open(key string) (io.ReadCloser, error) {
+	path, compressed, err := fs.storedPath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		errMsg := "failed to open file %s: %w"
+		return nil, fmt.Errorf(errMsg, key, err)
+	}
+	if !compressed {
+		return f, nil
+	}
+	d, err := zstd.NewReader(f)
+	if err != nil {
+		f.Close()
+		errMsg := "failed to create zstd reader for %s: %w"
+		return nil, fmt.Errorf(errMsg, key, err)
+	}
+	return &zstdFile{Decoder: d, f: f}, nil
+}
+
+// zstdFile closes both the decoder and the underlying file
+type zstdFile struct {
+	*zstd.Decoder
+	f *os.File
+}
+
+func (z *zstdFile) Close() error {
+	z.Decoder.Close()
+	return z.f.Close()
+}
+
+// Stats summarises the contents of the store
+type Stats struct {
+	Files        int
+	Compressed   int   // files kept zstd compressed
+	DiskBytes    int64 // space taken on disk
+	LogicalBytes int64 // total uncompressed size
+}
+
+func (fs *FileStore) Stats() Stats {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var st Stats
+	for _, e := range fs.entries {
+		st.Files++
+		if e.compressed {
+			st.Compressed++
+		}
+		st.DiskBytes += e.size
+		if e.logical > 0 {
+			st.LogicalBytes += e.logical
+		}
+	}
+	return st
+}
+
+// compressFile writes a zstd copy of src into tmpDir and returns its path.
+// The uncompressed size is recorded in the frame header, so that the
+// logical size is known without decompressing.
+func compressFile(src *os.File, size int64, tmpDir string) (string, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		errMsg := "failed to seek to start of %s: %w"
+		return "", fmt.Errorf(errMsg, src.Name(), err)
+	}
+
+	dst, err := os.Create(filepath.Join(tmpDir, uuid.New().String()+zstExt))
+	if err != nil {
+		errMsg := "failed to create temp file: %w"
+		return "", fmt.Errorf(errMsg, err)
+	}
+	defer dst.Close()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		os.Remove(dst.Name())
+		errMsg := "failed to create zstd writer: %w"
+		return "", fmt.Errorf(errMsg, err)
+	}
+	enc.ResetContentSize(dst, size)
+	if _, err := io.Copy(enc, src); err != nil {
+		enc.Close()
+		os.Remove(dst.Name())
+		errMsg := "failed to compress %s: %w"
+		return "", fmt.Errorf(errMsg, src.Name(), err)
+	}
+	if err := enc.Close(); err != nil {
+		os.Remove(dst.Name())
+		errMsg := "failed to compress %s: %w"
+		return "", fmt.Errorf(errMsg, src.Name(), err)
+	}
+	if err := dst.Sync(); err != nil {
+		os.Remove(dst.Name())
+		errMsg := "failed to sync temp file %s: %w"
+		return "", fmt.Errorf(errMsg, dst.Name(), err)
+	}
+	return dst.Name(), nil
+}
+
+// contentSize reads the uncompressed size from the zstd frame header.
+// Small files have no size in their header and are decompressed instead.
+// Returns -1 if the file cannot be read.
+func contentSize(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	buf := make([]byte, zstd.HeaderMaxSize)
+	n, _ := io.ReadFull(f, buf)
+	var h zstd.Header
+	if err := h.Decode(buf[:n]); err == nil && h.HasFCS {
+		return int64(h.FrameContentSize)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return -1
+	}
+	d, err := zstd.NewReader(f)
+	if err != nil {
+		return -1
+	}
+	defer d.Close()
+	size, err := io.Copy(io.Discard, d)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// parseStoredName splits a file name of the store into its key and
+// whether it is compressed
+func parseStoredName(name string) (string, bool, bool) {
+	key, compressed := strings.CutSuffix(name, zstExt)
+	if validateHexSha256(key) != nil {
+		return "", false, false
+	}
+	return key, compressed, true
+}
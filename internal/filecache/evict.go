@@ -0,0 +1,198 @@
+package filecache
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// entry is what the store tracks about a cached file for eviction.
+// The last access time is persisted as the file's mtime, which
+// survives restarts (unlike atime on noatime/relatime mounts).
+type entry struct {
+	size       int64 // on disk
+	logical    int64 // uncompressed; -1 if unknown
+	compressed bool
+	lastAccess time.Time
+	urls       []string  // scheduled sources, nil if stored directly
+	fetchedAt  time.Time // zero if unknown
+}
+
+// stored returns the path of the file described by the entry
+func (fs *FileStore) stored(key string, e *entry) string {
+	if e.compressed {
+		return fs.zstPath(key)
+	}
+	return fs.path(key)
+}
+
+// SetMaxBytes sets the disk budget of the store. When the cached files
+// exceed it, the least recently used ones that are not pinned are removed.
+// Zero or a negative value disables eviction.
+func (fs *FileStore) SetMaxBytes(maxBytes int64) {
+	fs.mu.Lock()
+	fs.maxBytes = maxBytes
+	fs.mu.Unlock()
+	fs.evict()
+}
+
+// Pin protects files from eviction while a job is using them.
+// Pins are counted; every Pin must be matched with an Unpin.
+func (fs *FileStore) Pin(keys ...string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, key := range keys {
+		fs.pins[key]++
+	}
+}
+
+// Unpin releases pins taken by Pin.
+func (fs *FileStore) Unpin(keys ...string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, key := range keys {
+		fs.pins[key]--
+		if fs.pins[key] <= 0 {
+			delete(fs.pins, key)
+		}
+	}
+}
+
+// UsedBytes returns the total size of the cached files.
+func (fs *FileStore) UsedBytes() int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.usedBytes
+}
+
+// loadEntries scans the file directory so that usage and access
+// times are known right after a restart.
+func (fs *FileStore) loadEntries() error {
+	dirEntries, err := os.ReadDir(fs.fileDir)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, de := range dirEntries {
+		if de.IsDir() {
+			continue
+		}
+		key, compressed, ok := parseStoredName(de.Name())
+		if !ok {
+			continue
+		}
+		if _, dup := fs.entries[key]; dup {
+			continue // both forms exist; the other one is tracked
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		e := &entry{size: info.Size(), logical: info.Size(), compressed: compressed, lastAccess: info.ModTime()}
+		if compressed {
+			e.logical = contentSize(filepath.Join(fs.fileDir, de.Name()))
+		}
+		fs.entries[key] = e
+		fs.usedBytes += info.Size()
+	}
+	return nil
+}
+
+// added records a file that has just been written to the store
+// from the given URLs and evicts older files if the budget is exceeded.
+func (fs *FileStore) added(key string, urls []string) {
+	path, compressed, err := fs.storedPath(key)
+	if err != nil {
+		errMsg := "failed to find stored file %s: %v"
+		log.Printf(errMsg, key, err)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		errMsg := "failed to stat stored file %s: %v"
+		log.Printf(errMsg, key, err)
+		return
+	}
+	e := &entry{size: info.Size(), logical: info.Size(), compressed: compressed, lastAccess: info.ModTime(),
+		urls: urls, fetchedAt: time.Now()}
+	if compressed {
+		e.logical = contentSize(path)
+	}
+
+	fs.mu.Lock()
+	if old, ok := fs.entries[key]; ok {
+		fs.usedBytes -= old.size
+	}
+	fs.entries[key] = e
+	fs.usedBytes += info.Size()
+	fs.markDirty()
+	fs.mu.Unlock()
+
+	fs.evict()
+}
+
+// touch marks the file as just used.
+func (fs *FileStore) touch(key string) {
+	now := time.Now()
+	fs.mu.Lock()
+	e, ok := fs.entries[key]
+	var path string
+	if ok {
+		e.lastAccess = now
+		path = fs.stored(key, e)
+		fs.markDirty()
+	}
+	fs.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := os.Chtimes(path, now, now); err != nil {
+		errMsg := "failed to update access time of %s: %v"
+		log.Printf(errMsg, key, err)
+	}
+}
+
+// evict removes least recently used unpinned files until
+// the store fits into its budget.
+func (fs *FileStore) evict() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.maxBytes <= 0 || fs.usedBytes <= fs.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(fs.entries))
+	for key := range fs.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fs.entries[keys[i]].lastAccess.Before(fs.entries[keys[j]].lastAccess)
+	})
+
+	for _, key := range keys {
+		if fs.usedBytes <= fs.maxBytes {
+			break
+		}
+		if fs.pins[key] > 0 {
+			continue
+		}
+		err := os.Remove(fs.stored(key, fs.entries[key]))
+		if err != nil && !os.IsNotExist(err) {
+			errMsg := "failed to evict file %s: %v"
+			log.Printf(errMsg, key, err)
+			continue
+		}
+		fs.usedBytes -= fs.entries[key].size
+		delete(fs.entries, key)
+		fs.markDirty()
+	}
+
+	if fs.usedBytes > fs.maxBytes {
+		errMsg := "file cache uses %d bytes over its budget of %d; remaining files are pinned"
+		log.Printf(errMsg, fs.usedBytes-fs.maxBytes, fs.maxBytes)
+	}
+}
@@ -0,0 +1,72 @@
+package filecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictLeastRecentlyUsedUnpinned(t *testing.T) {
+	fs := newTestStore(t)
+	var keys []string
+	for i, data := range []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc"} {
+		key, err := fs.Store([]byte(data))
+		if err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+		// the store does not tell apart files written within the same tick
+		fs.mu.Lock()
+		fs.entries[key].lastAccess = time.Unix(int64(i), 0)
+		fs.mu.Unlock()
+		keys = append(keys, key)
+	}
+	a, b, c := keys[0], keys[1], keys[2]
+	if used := fs.UsedBytes(); used != 30 {
+		t.Fatalf("UsedBytes = %d, want 30", used)
+	}
+
+	// a is the oldest, but pinned twice
+	fs.Pin(a, a)
+	fs.SetMaxBytes(20)
+	if !fs.Exists(a) || fs.Exists(b) || !fs.Exists(c) {
+		t.Fatalf("after evicting to 20 bytes: a=%v b=%v c=%v, want only b gone",
+			fs.Exists(a), fs.Exists(b), fs.Exists(c))
+	}
+	if used := fs.UsedBytes(); used != 20 {
+		t.Errorf("UsedBytes = %d, want 20", used)
+	}
+
+	fs.Unpin(a)
+	fs.SetMaxBytes(10)
+	if !fs.Exists(a) || fs.Exists(c) {
+		t.Fatalf("a is still pinned once: a=%v c=%v, want only c gone", fs.Exists(a), fs.Exists(c))
+	}
+
+	// over budget while pinned, evicted as soon as it is not
+	fs.SetMaxBytes(5)
+	if !fs.Exists(a) {
+		t.Fatal("pinned file was evicted")
+	}
+	fs.Unpin(a)
+	if _, err := fs.Store([]byte("d")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if fs.Exists(a) {
+		t.Error("unpinned file over the budget was not evicted")
+	}
+	if used := fs.UsedBytes(); used != 1 {
+		t.Errorf("UsedBytes = %d, want 1", used)
+	}
+}
+
+func TestUsedBytesSurviveRestart(t *testing.T) {
+	fs := newTestStore(t)
+	for _, data := range []string{"12345", "1234567890"} {
+		if _, err := fs.Store([]byte(data)); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	reopened := New(fs.fileDir, fs.tmpDir)
+	if used := reopened.UsedBytes(); used != 15 {
+		t.Errorf("UsedBytes after reopening = %d, want 15", used)
+	}
+}
@@ -0,0 +1,176 @@
+package filecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Fetcher retrieves the contents behind a URL of one scheme.
+// FileStore picks the fetcher by the scheme of a scheduled URL;
+// integrity is checked by the store, not by the fetcher.
+type Fetcher interface {
+	Fetch(ctx context.Context, u *url.URL) (*Fetched, error)
+}
+
+// Fetched is an open body returned by a Fetcher
+type Fetched struct {
+	Body io.ReadCloser
+	Size int64 // -1 if unknown
+	Zstd bool  // body is zstd compressed and must be decompressed
+}
+
+// RegisterFetcher makes the store accept URLs of the given scheme.
+// Only https is registered by default.
+func (fs *FileStore) RegisterFetcher(scheme string, f Fetcher) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.fetchers[strings.ToLower(scheme)] = f
+}
+
+func (fs *FileStore) fetcher(scheme string) (Fetcher, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.fetchers[strings.ToLower(scheme)]
+	if !ok {
+		errMsg := "unsupported URL scheme: %s"
+		return nil, fmt.Errorf(errMsg, scheme)
+	}
+	return f, nil
+}
+
+// isZstdPath tells whether the object name suggests zstd compression
+func isZstdPath(p string) bool {
+	return filepath.Ext(p) == ".zst"
+}
+
+// httpClient bounds connecting and waiting for the response headers;
+// a body that stalls later is aborted by the download watchdog
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   defaultWorkers,
+	},
+}
+
+// HTTPFetcher downloads over http(s), e.g. S3 presigned URLs.
+// Register it for "http" only in development.
+type HTTPFetcher struct {
+	Client *http.Client // a client with connect timeouts if nil
+}
+
+func (f HTTPFetcher) Fetch(ctx context.Context, u *url.URL) (*Fetched, error) {
+	return fetchHTTP(ctx, f.Client, u, nil)
+}
+
+// fetchHTTP sends a GET request with the given headers. 4xx responses
+// other than timeouts and rate limits are permanent failures.
+func fetchHTTP(ctx context.Context, client *http.Client, u *url.URL, header http.Header) (*Fetched, error) {
+	if client == nil {
+		client = httpClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		errMsg := "failed to create request for %s: %w"
+		return nil, fmt.Errorf(errMsg, u.Redacted(), err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		errMsg := "failed to download file from %s: %w"
+		return nil, fmt.Errorf(errMsg, u.Redacted(), err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		errMsg := "unexpected status code %d while downloading file from %s"
+		err := fmt.Errorf(errMsg, resp.StatusCode, u.Redacted())
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout &&
+			resp.StatusCode != http.StatusTooManyRequests {
+			return nil, permanent(err)
+		}
+		return nil, err
+	}
+
+	return &Fetched{
+		Body: resp.Body,
+		Size: resp.ContentLength,
+		Zstd: resp.Header.Get("Content-Type") == "application/zstd" || isZstdPath(u.Path),
+	}, nil
+}
+
+// FileFetcher reads file:// URLs, e.g. tests of a local problem directory.
+// Only files below Root can be read. Its failures are never retried.
+type FileFetcher struct {
+	Root string
+}
+
+func (f FileFetcher) Fetch(ctx context.Context, u *url.URL) (*Fetched, error) {
+	fetched, err := f.open(u)
+	if err != nil {
+		return nil, permanent(err)
+	}
+	return fetched, nil
+}
+
+func (f FileFetcher) open(u *url.URL) (*Fetched, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		errMsg := "file URL %s must not name a remote host"
+		return nil, fmt.Errorf(errMsg, u.String())
+	}
+
+	root, err := filepath.EvalSymlinks(f.Root)
+	if err != nil {
+		errMsg := "failed to resolve file root %s: %w"
+		return nil, fmt.Errorf(errMsg, f.Root, err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Clean(u.Path))
+	if err != nil {
+		errMsg := "failed to resolve file %s: %w"
+		return nil, fmt.Errorf(errMsg, u.Path, err)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		errMsg := "file %s is outside of the file root %s"
+		return nil, fmt.Errorf(errMsg, u.Path, f.Root)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		errMsg := "failed to open file %s: %w"
+		return nil, fmt.Errorf(errMsg, path, err)
+	}
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		errMsg := "failed to stat file %s: %w"
+		return nil, fmt.Errorf(errMsg, path, err)
+	}
+	if !st.Mode().IsRegular() {
+		file.Close()
+		errMsg := "file %s is not a regular file"
+		return nil, fmt.Errorf(errMsg, path)
+	}
+
+	return &Fetched{Body: file, Size: st.Size(), Zstd: isZstdPath(path)}, nil
+}
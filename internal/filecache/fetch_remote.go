@@ -0,0 +1,75 @@
+package filecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// S3Fetcher reads s3://bucket/key URLs from an S3-compatible endpoint
+// using the credentials of the client.
+type S3Fetcher struct {
+	Client *s3.Client
+}
+
+func (f S3Fetcher) Fetch(ctx context.Context, u *url.URL) (*Fetched, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	obj, err := f.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		errMsg := "failed to get s3://%s/%s: %w"
+		err = fmt.Errorf(errMsg, bucket, key, err)
+		// a missing object or a denied request stays so on retry
+		var resp interface{ HTTPStatusCode() int }
+		if errors.As(err, &resp) &&
+			(resp.HTTPStatusCode() == http.StatusForbidden || resp.HTTPStatusCode() == http.StatusNotFound) {
+			return nil, permanent(err)
+		}
+		return nil, err
+	}
+
+	size := int64(-1)
+	if obj.ContentLength != nil {
+		size = *obj.ContentLength
+	}
+	return &Fetched{
+		Body: obj.Body,
+		Size: size,
+		Zstd: aws.ToString(obj.ContentType) == "application/zstd" || isZstdPath(key),
+	}, nil
+}
+
+// NATSObjectFetcher reads natsobj://bucket/name URLs from a
+// JetStream object store.
+type NATSObjectFetcher struct {
+	JS jetstream.JetStream
+}
+
+func (f NATSObjectFetcher) Fetch(ctx context.Context, u *url.URL) (*Fetched, error) {
+	bucket, name := u.Host, strings.TrimPrefix(u.Path, "/")
+	store, err := f.JS.ObjectStore(ctx, bucket)
+	if err != nil {
+		errMsg := "failed to open object store %s: %w"
+		return nil, fmt.Errorf(errMsg, bucket, err)
+	}
+	obj, err := store.Get(ctx, name)
+	if err != nil {
+		errMsg := "failed to get object %s from %s: %w"
+		return nil, fmt.Errorf(errMsg, name, bucket, err)
+	}
+
+	size := int64(-1)
+	if info, err := obj.Info(); err == nil {
+		size = int64(info.Size)
+	}
+	return &Fetched{Body: obj, Size: size, Zstd: isZstdPath(name)}, nil
+}
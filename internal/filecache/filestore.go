@@ -1,12 +1,13 @@
 package filecache
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -14,19 +15,41 @@ import (
 	"sync"
 	"time"
 
-	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/google/uuid"
 	"github.com/klauspost/compress/zstd"
-	"github.com/puzpuzpuz/xsync/v3"
+	"golang.org/x/sync/singleflight"
 )
 
 type FileStore struct {
-	fileDir string             // file directory
-	tmpDir  string             // temporary directory
-	await   mapset.Set[string] // awaited keys (prioritized)
-	cond    *sync.Cond         // change announcements
-	queue   chan string        // queue of scheduled keys
-	urls    *xsync.MapOf[string, chan *url.URL]
+	fileDir string // file directory
+	tmpDir  string // temporary directory
+
+	cond     *sync.Cond      // change announcements; cond.L guards the jobs
+	ready    *sync.Cond      // wakes idle download workers
+	jobs     map[string]*job // scheduled downloads by key
+	prio     []string        // queue of awaited keys
+	queue    []string        // queue of scheduled keys
+	workers  int             // number of download workers, see SetWorkers
+	retry    retryPolicy     // attempts per URL, see SetRetry
+	watchdog watchdog        // aborts stalled downloads, see SetWatchdog
+
+	mu          sync.Mutex                   // guards the state below
+	maxBytes    int64                        // disk budget, see SetMaxBytes
+	usedBytes   int64                        // total size of cached files
+	entries     map[string]*entry            // cached files by key
+	pins        map[string]int               // keys used by in-flight jobs
+	fetchers    map[string]Fetcher           // by URL scheme, see RegisterFetcher
+	compress    bool                         // keep new files zstd compressed, see SetCompression
+	archives    map[string]map[string]string // unpacked archives: path to key by archive key
+	peers       map[string]time.Time         // peer base URLs by expiry, zero if static; see AddPeer
+	peerToken   string                       // bearer token between peers, see SetPeerToken
+	resume      map[string][]string          // pending downloads of the last run, see Start
+	dirty       bool                         // the index has changed since it was saved
+	lockFile    *os.File                     // flocked to mark the store in use, see lock
+	maintaining bool                         // locked exclusively, see Lock
+
+	saving    sync.Mutex         // serialises SaveIndex
+	unpacking singleflight.Group // archives being unpacked, see AwaitArchive
 }
 
 func New(fileDir string, tmpDir string) *FileStore {
@@ -43,65 +66,102 @@ func New(fileDir string, tmpDir string) *FileStore {
 		panic(fmt.Errorf(errMsg, tmpDir, err))
 	}
 
+	state := &sync.Mutex{}
 	fs := &FileStore{
-		fileDir: fileDir,
-		tmpDir:  tmpDir,
-		await:   mapset.NewSet[string](),
-		urls:    xsync.NewMapOf[string, chan *url.URL](),
-		queue:   make(chan string, 1024),
-		cond:    sync.NewCond(&sync.Mutex{}),
+		fileDir:  fileDir,
+		tmpDir:   tmpDir,
+		cond:     sync.NewCond(state),
+		ready:    sync.NewCond(state),
+		jobs:     make(map[string]*job),
+		workers:  defaultWorkers,
+		retry:    defaultRetry,
+		watchdog: defaultWatchdog,
+		entries:  make(map[string]*entry),
+		pins:     make(map[string]int),
+		archives: make(map[string]map[string]string),
+		peers:    make(map[string]time.Time),
+		resume:   make(map[string][]string),
+		fetchers: map[string]Fetcher{
+			"https": HTTPFetcher{},
+		},
+	}
+
+	err = fs.loadEntries()
+	if err != nil {
+		errMsg := "failed to scan directory %s: %w"
+		panic(fmt.Errorf(errMsg, fileDir, err))
+	}
+
+	err = fs.loadIndex()
+	if err != nil {
+		errMsg := "failed to load file cache index: %w"
+		panic(fmt.Errorf(errMsg, err))
 	}
 
 	return fs
 }
 
 // Await waits for the file to be downloaded and returns its contents.
-func (fs *FileStore) Await(key string) ([]byte, error) {
+// It gives up when ctx is done; the download itself continues.
+func (fs *FileStore) Await(ctx context.Context, key string) ([]byte, error) {
+	if err := fs.waitFor(ctx, key); err != nil {
+		return nil, err
+	}
+	return fs.Get(key)
+}
+
+// Wait waits for the file to be downloaded without reading it.
+// Use RawPath or Open afterwards.
+func (fs *FileStore) Wait(ctx context.Context, key string) error {
+	return fs.waitFor(ctx, key)
+}
+
+func (fs *FileStore) waitFor(ctx context.Context, key string) error {
 	if err := validateHexSha256(key); err != nil {
 		errMsg := "invalid file key %s: %w"
-		return nil, fmt.Errorf(errMsg, key, err)
+		return fmt.Errorf(errMsg, key, err)
 	}
 
 	fs.cond.L.Lock()
 	defer fs.cond.L.Unlock()
 
+	// wake up the loop below when the caller gives up
+	stop := context.AfterFunc(ctx, func() {
+		fs.cond.L.Lock()
+		defer fs.cond.L.Unlock()
+		fs.cond.Broadcast()
+	})
+	defer stop()
+
 	for !fs.Exists(key) {
-		urls, exists := fs.urls.Load(key)
+		if ctx.Err() != nil {
+			errMsg := "gave up waiting for file %s: %w"
+			return fmt.Errorf(errMsg, key, context.Cause(ctx))
+		}
+		j, exists := fs.jobs[key]
 		if !exists {
 			errMsg := "file %s has not been scheduled for download"
-			return nil, fmt.Errorf(errMsg, key)
+			return fmt.Errorf(errMsg, key)
 		}
-		if len(urls) == 0 {
-			errMsg := "file %s download has been unsuccessful"
-			return nil, fmt.Errorf(errMsg, key)
+		if j.failed {
+			errMsg := "file %s download has been unsuccessful: %w"
+			return fmt.Errorf(errMsg, key, j.err)
 		}
 
-		fs.await.Add(key)
+		fs.prioritize(key, j)
 		fs.cond.Wait()
 	}
 
-	return fs.Get(key)
+	fs.touch(key)
+	return nil
 }
 
+// Store stores data under its sha256 and returns the key. A file that is
+// already stored is left alone, as it may be hardlinked into running boxes;
+// new files are written to tmpDir and renamed into place.
 func (fs *FileStore) Store(data []byte) (string, error) {
-	// get sha256 hash of the data
-	hasher := sha256.New()
-	_, err := hasher.Write(data)
-	if err != nil {
-		errMsg := "failed to compute SHA256 of data: %w"
-		return "", fmt.Errorf(errMsg, err)
-	}
-	sha256Key := hex.EncodeToString(hasher.Sum(nil))
-
-	// save the data to the file store
-	filePath := fs.path(sha256Key)
-	err = os.WriteFile(filePath, data, 0644)
-	if err != nil {
-		errMsg := "failed to write data to file %s: %w"
-		return "", fmt.Errorf(errMsg, filePath, err)
-	}
-
-	return sha256Key, nil
+	key, _, err := fs.storeFrom(bytes.NewReader(data), -1, false)
+	return key, err
 }
 
 func (fs *FileStore) Schedule(sha256Key string, downlUrl string) error {
@@ -116,80 +176,67 @@ func (fs *FileStore) Schedule(sha256Key string, downlUrl string) error {
 		return fmt.Errorf(errMsg, downlUrl, err)
 	}
 
+	if _, err := fs.fetcher(parsedUrl.Scheme); err != nil {
+		errMsg := "cannot download file %s: %w"
+		return fmt.Errorf(errMsg, sha256Key, err)
+	}
+
 	fs.cond.L.Lock()
 	defer fs.cond.L.Unlock()
 
-	// if the map does not yet have a channel for this key, create one
-	// and send the URL to it, otherwise just send the URL to the existing
-	n := make(chan *url.URL, 4096)
-	n <- parsedUrl
-	c, loaded := fs.urls.LoadOrStore(sha256Key, n)
-	if loaded {
-		c <- parsedUrl
+	j, exists := fs.jobs[sha256Key]
+	if !exists {
+		j = &job{}
+		fs.jobs[sha256Key] = j
 	}
-
-	fs.queue <- sha256Key
-	fs.cond.Broadcast()
+	added := j.addURL(parsedUrl)
+	if added {
+		fs.mu.Lock()
+		fs.markDirty()
+		fs.mu.Unlock()
+	}
+	if j.failed {
+		// a failed key is retried from its first URL when scheduled again
+		j.failed, j.err, j.next, j.peersTried = false, nil, 0, false
+	} else if !added {
+		return nil
+	}
+	fs.enqueue(sha256Key, j)
 
 	return nil
 }
 
-// Start starts a indefinite loop for downloading files.
-// It downloads files in the order of their arrival,
+// Start runs the download workers and blocks indefinitely.
+// Files are downloaded in the order of their arrival,
 // prioritizing those files that are currently awaited by the tester.
+// Start claims the store unless the caller has (see Claim). Downloads
+// interrupted by the last restart are resumed, their temp files removed,
+// and the index is saved from now on as the store changes.
 func (fs *FileStore) Start() {
-	for {
-		awaited := fs.await.ToSlice()
-		for _, key := range awaited {
-			err := fs.download(key)
-			if err != nil {
-				errMsg := "failed to download file %s: %v"
-				log.Printf(errMsg, key, err)
-				continue
-			}
-		}
-		// choose some random key from not awaited
-		key := <-fs.queue
-		err := fs.download(key)
-		if err != nil {
-			errMsg := "failed to download file %s: %v"
-			log.Printf(errMsg, key, err)
-		}
+	// temp files are only orphans if no other process uses the store
+	if err := fs.Claim(); err != nil {
+		log.Printf("file cache is not claimed, leaving its temp files: %v", err)
+	} else {
+		fs.cleanTmpDir()
 	}
-}
+	fs.resumeDownloads()
+	go fs.flushIndex()
 
-// Ensures file exists on its path or otherwise downloads it.
-// Loads the condition variable and announces that the file exists.
-func (fs *FileStore) download(key string) error {
 	fs.cond.L.Lock()
-	defer fs.cond.L.Unlock()
-
-	urls, exists := fs.urls.Load(key)
-	if !exists {
-		errMsg := "file %s has not been scheduled for download"
-		return fmt.Errorf(errMsg, key)
-	}
-
-	urlSlice := make([]*url.URL, 0, len(urls))
-	for len(urls) > 0 {
-		urlSlice = append(urlSlice, <-urls)
-	}
-
-	for _, url := range urlSlice {
-		if fs.Exists(key) {
-			break
-		}
-		err := download(url.String(), fs.tmpDir, fs.path(key), key)
-		if err != nil {
-			errMsg := "failed to download file %s from %s: %w"
-			fs.cond.Broadcast()
-			return fmt.Errorf(errMsg, key, url.String(), err)
-		}
-		fs.await.Remove(key)
-		fs.cond.Broadcast()
+	workers := fs.workers
+	fs.cond.L.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				fs.work(fs.nextJob())
+			}
+		}()
 	}
-
-	return nil
+	wg.Wait()
 }
 
 func (fs *FileStore) path(key string) string {
@@ -197,8 +244,12 @@ func (fs *FileStore) path(key string) string {
 }
 
 func (fs *FileStore) Get(key string) ([]byte, error) {
-	filePath := fs.path(key)
-	data, err := os.ReadFile(filePath)
+	r, err := fs.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
 	if err != nil {
 		errMsg := "failed to read file %s: %w"
 		return nil, fmt.Errorf(errMsg, key, err)
@@ -207,26 +258,39 @@ func (fs *FileStore) Get(key string) ([]byte, error) {
 }
 
 func (fs *FileStore) Exists(key string) bool {
-	_, err := os.Stat(fs.path(key))
+	_, _, err := fs.storedPath(key)
 	return err == nil
 }
 
-// Downloads a file from the given URL which is likely to be an S3 presigned URL.
-// If the file is compressed with zstd, as indicated by the Content-Type or ext,
-// it will be decompressed before saving. URL scheme must be HTTPS.
-// Adds integrity check using a provided SHA256 hash.
-func download(downlURL string, tmpDir string, saveToPath string, expectedSha256 string) error {
-	u, err := url.Parse(downlURL)
+// fetchTo downloads the file with the fetcher registered for the URL scheme.
+// The download is aborted when the watchdog finds it stalled.
+func (fs *FileStore) fetchTo(u *url.URL, key string, prog *progress, wd watchdog) error {
+	f, err := fs.fetcher(u.Scheme)
 	if err != nil {
-		errMsg := "failed to parse URL %s: %w"
-		return fmt.Errorf(errMsg, downlURL, err)
+		return permanent(err)
 	}
+	return fs.fetchWith(f, u, key, prog, wd)
+}
 
-	if u.Scheme != "https" {
-		errMsg := "invalid URL scheme: %s"
-		return fmt.Errorf(errMsg, u.Scheme)
+// fetchWith downloads the file with the given fetcher into the store
+func (fs *FileStore) fetchWith(f Fetcher, u *url.URL, key string, prog *progress, wd watchdog) error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	stop := wd.watch(cancel, prog)
+	defer stop()
+
+	if fs.compression() {
+		return download(ctx, f, u, fs.tmpDir, fs.zstPath(key), key, prog, true)
 	}
+	return download(ctx, f, u, fs.tmpDir, fs.path(key), key, prog, false)
+}
 
+// Downloads a file from the given URL using the fetcher of its scheme.
+// If the file is compressed with zstd, as reported by the fetcher,
+// it will be decompressed before saving.
+// Adds integrity check using a provided SHA256 hash.
+// With compress, the verified file is saved zstd compressed.
+func download(ctx context.Context, f Fetcher, u *url.URL, tmpDir string, saveToPath string, expectedSha256 string, prog *progress, compress bool) error {
 	// Validate the expected SHA256 hash
 	if err := validateHexSha256(expectedSha256); err != nil {
 		errMsg := "invalid expected SHA256 hash %s: %w"
@@ -243,23 +307,19 @@ func download(downlURL string, tmpDir string, saveToPath string, expectedSha256
 		os.Remove(tmpFile.Name()) // Clean up temp file in case of failure
 	}()
 
-	log.Printf("Downloading file from %s to temporary path %s", downlURL, tmpFile.Name())
-	resp, err := http.Get(downlURL)
+	log.Printf("Downloading file from %s to temporary path %s", u.Redacted(), tmpFile.Name())
+	fetched, err := f.Fetch(ctx, u)
 	if err != nil {
-		errMsg := "failed to download file from %s: %w"
-		return fmt.Errorf(errMsg, downlURL, err)
+		return cancelCause(ctx, err)
 	}
-	defer resp.Body.Close()
+	defer fetched.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		errMsg := "unexpected status code %d while downloading file from %s"
-		return fmt.Errorf(errMsg, resp.StatusCode, downlURL)
-	}
+	prog.total.Store(fetched.Size)
+	body := &countingReader{r: fetched.Body, n: &prog.done}
 
-	if (resp.Header.Get("Content-Type") == "application/zstd") ||
-		filepath.Ext(u.Path) == ".zst" {
+	if fetched.Zstd {
 
-		d, err := zstd.NewReader(resp.Body)
+		d, err := zstd.NewReader(body)
 		if err != nil {
 			errMsg := "failed to create zstd reader: %w"
 			return fmt.Errorf(errMsg, err)
@@ -269,15 +329,15 @@ func download(downlURL string, tmpDir string, saveToPath string, expectedSha256
 		_, err = io.Copy(tmpFile, d)
 		if err != nil {
 			errMsg := "failed to write decompressed file to %s: %w"
-			return fmt.Errorf(errMsg, tmpFile.Name(), err)
+			return fmt.Errorf(errMsg, tmpFile.Name(), cancelCause(ctx, err))
 		}
 
 	} else {
 
-		_, err = io.Copy(tmpFile, resp.Body)
+		_, err = io.Copy(tmpFile, body)
 		if err != nil {
 			errMsg := "failed to write file to %s: %w"
-			return fmt.Errorf(errMsg, tmpFile.Name(), err)
+			return fmt.Errorf(errMsg, tmpFile.Name(), cancelCause(ctx, err))
 		}
 
 	}
@@ -294,34 +354,55 @@ func download(downlURL string, tmpDir string, saveToPath string, expectedSha256
 		return fmt.Errorf(errMsg, tmpFile.Name(), err)
 	}
 	hasher := sha256.New()
-	if _, err := io.Copy(hasher, tmpFile); err != nil {
+	size, err := io.Copy(hasher, tmpFile)
+	if err != nil {
 		errMsg := "failed to compute SHA256 of temp file %s: %w"
 		return fmt.Errorf(errMsg, tmpFile.Name(), err)
 	}
 	computedHash := hex.EncodeToString(hasher.Sum(nil))
 	if computedHash != expectedSha256 {
 		errMsg := "SHA256 mismatch for file %s: expected %s, got %s"
-		return fmt.Errorf(errMsg, saveToPath, expectedSha256, computedHash)
+		return permanent(fmt.Errorf(errMsg, saveToPath, expectedSha256, computedHash))
+	}
+
+	if err := commitFile(tmpFile, size, tmpDir, saveToPath, compress); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully downloaded and moved file to %s", saveToPath)
+	return nil
+}
+
+// commitFile moves a verified temporary file to its place in the store,
+// compressing it first if requested.
+func commitFile(tmpFile *os.File, size int64, tmpDir string, saveToPath string, compress bool) error {
+	srcPath := tmpFile.Name()
+	if compress {
+		var err error
+		srcPath, err = compressFile(tmpFile, size, tmpDir)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(srcPath)
 	}
 
 	// Rename the temporary file to the target path atomically
-	if err := os.Rename(tmpFile.Name(), saveToPath); err != nil {
+	if err := os.Rename(srcPath, saveToPath); err != nil {
 		if !strings.Contains(err.Error(), "cross-device") &&
 			!strings.Contains(err.Error(), "EXDEV") {
 			errMsg := "failed to rename temp file %s to %s: %w"
-			return fmt.Errorf(errMsg, tmpFile.Name(), saveToPath, err)
+			return fmt.Errorf(errMsg, srcPath, saveToPath, err)
 		} else {
-			err := copyFile(tmpFile.Name(), saveToPath)
+			err := copyFile(srcPath, saveToPath)
 			if err != nil {
 				errMsg := "failed to copy temp file %s to %s: %w"
-				return fmt.Errorf(errMsg, tmpFile.Name(), saveToPath, err)
+				return fmt.Errorf(errMsg, srcPath, saveToPath, err)
 			}
 		}
 	}
-
-	log.Printf("Successfully downloaded and moved file to %s", saveToPath)
 	return nil
 }
+
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -0,0 +1,46 @@
+package filecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *FileStore {
+	t.Helper()
+	dir := t.TempDir()
+	return New(filepath.Join(dir, "files"), filepath.Join(dir, "tmp"))
+}
+
+func TestStoreLeavesExistingFileAlone(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		fs := newTestStore(t)
+		fs.SetCompression(compress)
+		key, err := fs.Store([]byte("42\n"))
+		if err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+		if data, err := fs.Get(key); err != nil || string(data) != "42\n" {
+			t.Fatalf("compress=%v: Get = %q, %v", compress, data, err)
+		}
+
+		// stands in for a box reading the hardlinked file: storing the
+		// key again must not truncate or rewrite it
+		path, _, err := fs.storedPath(key)
+		if err != nil {
+			t.Fatalf("stored file not found: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("in use"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if again, err := fs.Store([]byte("42\n")); err != nil || again != key {
+			t.Fatalf("Store again: %s, %v", again, err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "in use" {
+			t.Errorf("compress=%v: the stored file was rewritten", compress)
+		}
+		if entries, _ := os.ReadDir(fs.tmpDir); len(entries) != 0 {
+			t.Errorf("compress=%v: %d files left in tmpDir", compress, len(entries))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package filecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// indexName is the file in fileDir that keeps what the store knows
+// across restarts. The files themselves remain the source of truth:
+// entries of missing files are dropped and unknown files are added.
+const indexName = "index.json"
+
+// indexFlushInterval bounds how often a changed index is written
+const indexFlushInterval = 2 * time.Second
+
+type index struct {
+	Files   map[string]indexEntry `json:"files"`
+	Pending map[string][]string   `json:"pending"` // URLs of keys not downloaded yet
+}
+
+type indexEntry struct {
+	Size       int64     `json:"size"`    // on disk
+	Logical    int64     `json:"logical"` // uncompressed; -1 if unknown
+	Compressed bool      `json:"compressed"`
+	URLs       []string  `json:"urls,omitempty"` // where the file was scheduled from
+	FetchedAt  time.Time `json:"fetched_at,omitzero"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+func (fs *FileStore) indexPath() string {
+	return filepath.Join(fs.fileDir, indexName)
+}
+
+// loadIndex merges the saved metadata into the entries found on disk
+// and remembers the pending downloads for Start. Must run after loadEntries.
+func (fs *FileStore) loadIndex() error {
+	data, err := os.ReadFile(fs.indexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		errMsg := "ignoring corrupt file cache index %s: %v"
+		log.Printf(errMsg, fs.indexPath(), err)
+		return nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for key, ie := range idx.Files {
+		e, ok := fs.entries[key]
+		if !ok {
+			continue // evicted or removed while the index was stale
+		}
+		e.urls = ie.URLs
+		e.fetchedAt = ie.FetchedAt
+		if e.logical < 0 && e.compressed == ie.Compressed {
+			e.logical = ie.Logical
+		}
+		if ie.LastAccess.After(e.lastAccess) {
+			e.lastAccess = ie.LastAccess
+		}
+	}
+	for key, urls := range idx.Pending {
+		if _, ok := fs.entries[key]; !ok && validateHexSha256(key) == nil {
+			fs.resume[key] = urls
+		}
+	}
+	return nil
+}
+
+// SaveIndex writes the metadata of the cached files and the pending
+// downloads to disk. Start saves it periodically; call it after
+// changing a store that is not started.
+func (fs *FileStore) SaveIndex() error {
+	fs.saving.Lock()
+	defer fs.saving.Unlock()
+
+	idx := index{
+		Files:   make(map[string]indexEntry),
+		Pending: make(map[string][]string),
+	}
+
+	fs.cond.L.Lock()
+	for key, j := range fs.jobs {
+		if j.failed {
+			continue
+		}
+		idx.Pending[key] = j.urlStrings()
+	}
+	fs.cond.L.Unlock()
+
+	fs.mu.Lock()
+	for key, urls := range fs.resume {
+		idx.Pending[key] = urls
+	}
+	for key, e := range fs.entries {
+		idx.Files[key] = indexEntry{
+			Size:       e.size,
+			Logical:    e.logical,
+			Compressed: e.compressed,
+			URLs:       e.urls,
+			FetchedAt:  e.fetchedAt,
+			LastAccess: e.lastAccess,
+		}
+		delete(idx.Pending, key)
+	}
+	fs.dirty = false
+	fs.mu.Unlock()
+
+	if err := fs.writeIndex(idx); err != nil {
+		fs.mu.Lock()
+		fs.markDirty() // try again at the next flush
+		fs.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStore) writeIndex(idx index) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		errMsg := "failed to marshal file cache index: %w"
+		return fmt.Errorf(errMsg, err)
+	}
+	tmpPath := fs.indexPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		errMsg := "failed to write file cache index: %w"
+		return fmt.Errorf(errMsg, err)
+	}
+	if err := os.Rename(tmpPath, fs.indexPath()); err != nil {
+		os.Remove(tmpPath)
+		errMsg := "failed to replace file cache index: %w"
+		return fmt.Errorf(errMsg, err)
+	}
+	return nil
+}
+
+// markDirty schedules the index to be saved. Must be called with mu held.
+func (fs *FileStore) markDirty() {
+	fs.dirty = true
+}
+
+// flushIndex saves the index whenever it has changed
+func (fs *FileStore) flushIndex() {
+	for range time.Tick(indexFlushInterval) {
+		fs.mu.Lock()
+		dirty := fs.dirty
+		fs.mu.Unlock()
+		if !dirty {
+			continue
+		}
+		if err := fs.SaveIndex(); err != nil {
+			log.Printf("failed to save file cache index: %v", err)
+		}
+	}
+}
+
+// resumeDownloads schedules the downloads that were pending
+// when the index was last saved
+func (fs *FileStore) resumeDownloads() {
+	fs.mu.Lock()
+	resume := fs.resume
+	fs.resume = make(map[string][]string)
+	fs.mu.Unlock()
+
+	for key, urls := range resume {
+		for _, u := range urls {
+			if err := fs.Schedule(key, u); err != nil {
+				errMsg := "failed to resume download of file %s: %v"
+				log.Printf(errMsg, key, err)
+			}
+		}
+	}
+	if len(resume) > 0 {
+		log.Printf("resumed %d pending downloads", len(resume))
+	}
+}
+
+// cleanTmpDir removes temporary files left behind by downloads that
+// were interrupted by a restart. Only names the store creates are touched.
+// The caller must hold the exclusive lock, as tmpDir is shared by all
+// processes using the store.
+func (fs *FileStore) cleanTmpDir() {
+	dirEntries, err := os.ReadDir(fs.tmpDir)
+	if err != nil {
+		errMsg := "failed to read tmp directory %s: %v"
+		log.Printf(errMsg, fs.tmpDir, err)
+		return
+	}
+	removed := 0
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() {
+			continue
+		}
+		name, _ := strings.CutSuffix(de.Name(), zstExt)
+		if uuid.Validate(name) != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(fs.tmpDir, de.Name())); err == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("removed %d orphaned temp files from %s", removed, fs.tmpDir)
+	}
+}
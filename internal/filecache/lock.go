@@ -0,0 +1,73 @@
+package filecache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockName is the file in fileDir that processes using the store flock.
+// A worker holds it exclusively while it runs, as do the maintenance
+// commands, so only one process uses a store at a time.
+const lockName = "lock"
+
+// ErrInUse is returned by Claim and Lock while another process is using
+// the store
+var ErrInUse = errors.New("file cache is in use by a running worker")
+
+// Claim takes the store for the lifetime of a worker process. Pins, the
+// size accounting and index.json are kept per process, so a second worker
+// on the same store would evict files pinned by the first and overwrite
+// its index; Claim fails with ErrInUse instead.
+func (fs *FileStore) Claim() error {
+	err := fs.lock(syscall.LOCK_EX | syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrInUse
+	}
+	return err
+}
+
+// Lock takes the store over for maintenance, which Remove and Verify
+// require. It fails with ErrInUse instead of waiting for workers to stop.
+func (fs *FileStore) Lock() error {
+	if err := fs.Claim(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	fs.maintaining = true
+	fs.mu.Unlock()
+	return nil
+}
+
+// checkLocked fails unless the store has been locked for maintenance
+func (fs *FileStore) checkLocked() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.maintaining {
+		return errors.New("file cache must be locked for maintenance, see Lock")
+	}
+	return nil
+}
+
+// lock opens the lock file on first use and applies the flock operation
+// to it.
+func (fs *FileStore) lock(how int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.lockFile == nil {
+		path := filepath.Join(fs.fileDir, lockName)
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			errMsg := "failed to open lock file %s: %w"
+			return fmt.Errorf(errMsg, path, err)
+		}
+		fs.lockFile = f
+	}
+	if err := syscall.Flock(int(fs.lockFile.Fd()), how); err != nil {
+		errMsg := "failed to lock %s: %w"
+		return fmt.Errorf(errMsg, fs.lockFile.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package filecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FileInfo describes a cached file, see List
+type FileInfo struct {
+	Key        string
+	Size       int64 // on disk
+	Logical    int64 // uncompressed; -1 if unknown
+	Compressed bool
+	LastAccess time.Time
+}
+
+// List returns the cached files, most recently used first.
+func (fs *FileStore) List() []FileInfo {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	files := make([]FileInfo, 0, len(fs.entries))
+	for key, e := range fs.entries {
+		files = append(files, FileInfo{
+			Key:        key,
+			Size:       e.size,
+			Logical:    e.logical,
+			Compressed: e.compressed,
+			LastAccess: e.lastAccess,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].LastAccess.After(files[j].LastAccess)
+	})
+	return files
+}
+
+// Remove deletes a cached file. Pinned files are removed as well,
+// so the store must be locked with Lock, which fails while workers run.
+func (fs *FileStore) Remove(key string) error {
+	if err := fs.checkLocked(); err != nil {
+		return err
+	}
+	if err := validateHexSha256(key); err != nil {
+		errMsg := "invalid file key %s: %w"
+		return fmt.Errorf(errMsg, key, err)
+	}
+	if !fs.Exists(key) {
+		errMsg := "file %s is not in the store"
+		return fmt.Errorf(errMsg, key)
+	}
+
+	for _, path := range []string{fs.path(key), fs.zstPath(key)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			errMsg := "failed to remove file %s: %w"
+			return fmt.Errorf(errMsg, key, err)
+		}
+	}
+	fs.forget(key)
+	return nil
+}
+
+// Verify re-hashes every cached file. Files whose contents no longer
+// match their key are moved to quarantineDir and dropped from the store.
+// Returns the keys of the moved files. The store must be locked with Lock.
+func (fs *FileStore) Verify(quarantineDir string) ([]string, error) {
+	if err := fs.checkLocked(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		errMsg := "failed to create directory %s: %w"
+		return nil, fmt.Errorf(errMsg, quarantineDir, err)
+	}
+
+	var bad []string
+	for _, f := range fs.List() {
+		if fs.hashOf(f.Key) == f.Key {
+			continue
+		}
+		path, _, err := fs.storedPath(f.Key)
+		if err != nil {
+			continue // removed meanwhile
+		}
+		dst := filepath.Join(quarantineDir, filepath.Base(path))
+		if err := os.Rename(path, dst); err != nil {
+			errMsg := "failed to quarantine file %s: %w"
+			return bad, fmt.Errorf(errMsg, f.Key, err)
+		}
+		fs.forget(f.Key)
+		bad = append(bad, f.Key)
+	}
+	return bad, nil
+}
+
+// hashOf returns the sha256 of the uncompressed contents of a stored file,
+// or an empty string if it cannot be read
+func (fs *FileStore) hashOf(key string) string {
+	r, err := fs.Open(key)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// forget drops the entry of a file that is no longer on disk
+func (fs *FileStore) forget(key string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if e, ok := fs.entries[key]; ok {
+		fs.usedBytes -= e.size
+		delete(fs.entries, key)
+		fs.markDirty()
+	}
+}
+
+// ValidateKey checks that the key is a lowercase hex sha256, as used by the store
+func ValidateKey(key string) error {
+	return validateHexSha256(key)
+}
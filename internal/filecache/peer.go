@@ -0,0 +1,195 @@
+package filecache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// PeerPath is where PeerHandler serves files, followed by the key
+const PeerPath = "/files/"
+
+// maxPeerTime bounds how long a download keeps trying peers before it
+// falls back to the origin URLs. A transfer that has started is not cut.
+const maxPeerTime = 10 * time.Second
+
+// peerClient gives up quickly on peers that are down or overloaded;
+// a body that stalls later is aborted by the download watchdog
+var peerClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   2 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: 3 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   defaultWorkers,
+	},
+}
+
+// PeerSignature authenticates the announcement of a peer base URL:
+// the hex HMAC-SHA256 of the URL keyed with the peer token. Workers
+// send their token only to peers that prove they know it this way.
+func PeerSignature(token string, baseURL string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(baseURL))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AddPeer makes the store try another worker's PeerHandler before the
+// origin URLs of a file. baseURL is e.g. "http://worker-2:8091".
+// A peer is forgotten after ttl unless added again; zero keeps it forever.
+func (fs *FileStore) AddPeer(baseURL string, ttl time.Duration) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if old, ok := fs.peers[baseURL]; ok && old.IsZero() {
+		return // static peers never expire
+	}
+	if _, ok := fs.peers[baseURL]; !ok {
+		log.Printf("added file cache peer %s", baseURL)
+	}
+	fs.peers[baseURL] = expires
+}
+
+// SetPeerToken sets the bearer token that PeerHandler requires and
+// that is sent to peers. Empty disables authentication.
+func (fs *FileStore) SetPeerToken(token string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.peerToken = token
+}
+
+// livePeers returns the base URLs of unexpired peers in random order,
+// so that the load spreads across them
+func (fs *FileStore) livePeers() ([]string, string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := time.Now()
+	var live []string
+	for baseURL, expires := range fs.peers {
+		if !expires.IsZero() && now.After(expires) {
+			delete(fs.peers, baseURL)
+			continue
+		}
+		live = append(live, baseURL)
+	}
+	rand.Shuffle(len(live), func(i, j int) {
+		live[i], live[j] = live[j], live[i]
+	})
+	return live, fs.peerToken
+}
+
+// fetchFromPeers tries to download the file from each peer once,
+// for at most maxPeerTime. Failures are expected (the peer may not have
+// the file) and are not counted against the origin URLs.
+func (fs *FileStore) fetchFromPeers(key string, peers []string, token string, prog *progress, wd watchdog) bool {
+	f := peerFetcher{token: token}
+	start := time.Now()
+	for i, baseURL := range peers {
+		if time.Since(start) > maxPeerTime {
+			errMsg := "file %s: skipping %d peers after trying them for %s"
+			log.Printf(errMsg, key, len(peers)-i, maxPeerTime)
+			return false
+		}
+		u, err := url.Parse(baseURL + PeerPath + key)
+		if err != nil {
+			errMsg := "invalid peer URL %s: %v"
+			log.Printf(errMsg, baseURL, err)
+			continue
+		}
+		prog.done.Store(0)
+		prog.total.Store(-1)
+		if err := fs.fetchWith(f, u, key, prog, wd); err != nil {
+			errMsg := "file %s is not available from peer %s: %v"
+			log.Printf(errMsg, key, baseURL, err)
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// peerFetcher downloads from another worker's PeerHandler
+type peerFetcher struct {
+	token string
+}
+
+func (f peerFetcher) Fetch(ctx context.Context, u *url.URL) (*Fetched, error) {
+	header := http.Header{}
+	if f.token != "" {
+		header.Set("Authorization", "Bearer "+f.token)
+	}
+	return fetchHTTP(ctx, peerClient, u, header)
+}
+
+// PeerHandler serves stored files to other workers at PeerPath + key.
+// Compressed files are sent as they are, with Content-Type application/zstd.
+// Receivers check the sha256 as with any other download.
+func (fs *FileStore) PeerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fs.mu.Lock()
+		token := fs.peerToken
+		fs.mu.Unlock()
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		key, ok := strings.CutPrefix(r.URL.Path, PeerPath)
+		if !ok || validateHexSha256(key) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		path, compressed, err := fs.storedPath(key)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.NotFound(w, r) // evicted meanwhile
+			return
+		}
+		defer f.Close()
+		st, err := f.Stat()
+		if err != nil {
+			errMsg := "failed to stat file %s: %v"
+			http.Error(w, fmt.Sprintf(errMsg, key, err), http.StatusInternalServerError)
+			return
+		}
+
+		if compressed {
+			w.Header().Set("Content-Type", "application/zstd")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		fs.touch(key)
+		http.ServeContent(w, r, "", st.ModTime(), f)
+	})
+}
@@ -0,0 +1,244 @@
+package filecache
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"time"
+)
+
+const defaultWorkers = 4
+
+var defaultRetry = retryPolicy{attempts: 3, base: time.Second, max: 30 * time.Second}
+
+// job is the download state of a scheduled key. It lives in
+// FileStore.jobs until the file is stored and is guarded by cond.L.
+type job struct {
+	urls    []*url.URL // candidate URLs in order of scheduling
+	next    int        // index of the next URL to try
+	queued  bool       // waiting for a worker
+	running bool       // a worker is downloading it
+	awaited bool       // someone waits for it; moved to the priority queue
+	failed  bool       // every URL has failed; cleared by Schedule
+	err     error      // last download error
+
+	peersTried bool // peers were asked before the URLs, see AddPeer
+
+	progress *progress // of the current download, nil if none
+}
+
+// addURL appends the URL unless the job already has it.
+// Reports whether the URL is new.
+func (j *job) addURL(u *url.URL) bool {
+	for _, known := range j.urls {
+		if known.String() == u.String() {
+			return false
+		}
+	}
+	j.urls = append(j.urls, u)
+	return true
+}
+
+func (j *job) urlStrings() []string {
+	urls := make([]string, len(j.urls))
+	for i, u := range j.urls {
+		urls[i] = u.String()
+	}
+	return urls
+}
+
+// retryPolicy bounds download attempts of a single URL
+type retryPolicy struct {
+	attempts int
+	base     time.Duration // delay after the first failure
+	max      time.Duration // upper bound of the delay
+}
+
+// delay is an exponential backoff with jitter
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.base << (attempt - 1)
+	if d <= 0 || d > p.max {
+		d = p.max
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// permanentError marks a failure that retrying the same URL cannot fix,
+// e.g. a sha256 mismatch or a 404
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// SetWorkers sets how many files are downloaded in parallel.
+// Must be called before Start.
+func (fs *FileStore) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	fs.cond.L.Lock()
+	defer fs.cond.L.Unlock()
+	fs.workers = n
+}
+
+// SetRetry sets how many times a URL is attempted and the backoff between
+// the attempts. The delay doubles after every failure up to maxDelay.
+func (fs *FileStore) SetRetry(attempts int, baseDelay time.Duration, maxDelay time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	fs.cond.L.Lock()
+	defer fs.cond.L.Unlock()
+	fs.retry = retryPolicy{attempts: attempts, base: baseDelay, max: maxDelay}
+}
+
+// enqueue hands the job to a worker unless one already has it.
+// A running worker picks up URLs added meanwhile by itself.
+// Must be called with cond.L held.
+func (fs *FileStore) enqueue(key string, j *job) {
+	if j.queued || j.running {
+		return
+	}
+	j.queued = true
+	if j.awaited {
+		fs.prio = append(fs.prio, key)
+	} else {
+		fs.queue = append(fs.queue, key)
+	}
+	fs.ready.Signal()
+}
+
+// prioritize moves a queued job ahead of those nobody waits for.
+// Must be called with cond.L held.
+func (fs *FileStore) prioritize(key string, j *job) {
+	if j.awaited {
+		return
+	}
+	j.awaited = true
+	if j.queued {
+		// the stale entry in the normal queue is skipped by nextJob
+		fs.prio = append(fs.prio, key)
+		fs.ready.Signal()
+	}
+}
+
+// nextJob blocks until a job is queued and claims it for the caller.
+func (fs *FileStore) nextJob() string {
+	fs.cond.L.Lock()
+	defer fs.cond.L.Unlock()
+
+	for {
+		var key string
+		switch {
+		case len(fs.prio) > 0:
+			key, fs.prio = fs.prio[0], fs.prio[1:]
+		case len(fs.queue) > 0:
+			key, fs.queue = fs.queue[0], fs.queue[1:]
+		default:
+			fs.ready.Wait()
+			continue
+		}
+
+		j, exists := fs.jobs[key]
+		if !exists || !j.queued {
+			continue // already claimed from the other queue
+		}
+		j.queued = false
+		j.running = true
+		return key
+	}
+}
+
+// work downloads a claimed job, asking the peers first and then trying
+// its URLs in order until one succeeds.
+// Announces the outcome to the waiters.
+func (fs *FileStore) work(key string) {
+	for {
+		fs.cond.L.Lock()
+		j := fs.jobs[key]
+		if fs.Exists(key) {
+			delete(fs.jobs, key)
+			fs.cond.Broadcast()
+			fs.cond.L.Unlock()
+			return
+		}
+		if !j.peersTried {
+			j.peersTried = true
+			peers, token := fs.livePeers()
+			if len(peers) > 0 {
+				urls := j.urlStrings()
+				wd := fs.watchdog
+				prog := &progress{}
+				j.progress = prog
+				fs.cond.L.Unlock()
+
+				if fs.fetchFromPeers(key, peers, token, prog, wd) {
+					fs.added(key, urls)
+				}
+				continue
+			}
+		}
+		if j.next >= len(j.urls) {
+			j.running = false
+			j.awaited = false
+			j.failed = true
+			j.progress = nil
+			lastErr := j.err
+			fs.mu.Lock()
+			fs.markDirty() // failed jobs are not resumed
+			fs.mu.Unlock()
+			fs.cond.Broadcast()
+			fs.cond.L.Unlock()
+
+			errMsg := "failed to download file %s from any of its URLs: %v"
+			log.Printf(errMsg, key, lastErr)
+			return
+		}
+		u := j.urls[j.next]
+		urls := j.urlStrings()
+		j.next++
+		retry, wd := fs.retry, fs.watchdog
+		prog := &progress{}
+		j.progress = prog
+		fs.cond.L.Unlock()
+
+		err := fs.fetchWithRetry(key, u, retry, prog, wd)
+		if err == nil {
+			fs.added(key, urls)
+			continue // the next iteration finds the file
+		}
+
+		errMsg := "failed to download file %s from %s: %v"
+		log.Printf(errMsg, key, u.Redacted(), err)
+		fs.cond.L.Lock()
+		j.err = err
+		fs.cond.L.Unlock()
+	}
+}
+
+func (fs *FileStore) fetchWithRetry(key string, u *url.URL, retry retryPolicy, prog *progress, wd watchdog) error {
+	for attempt := 1; ; attempt++ {
+		prog.done.Store(0)
+		prog.total.Store(-1)
+		err := fs.fetchTo(u, key, prog, wd)
+		if err == nil || isPermanent(err) || attempt >= retry.attempts {
+			return err
+		}
+		delay := retry.delay(attempt)
+		errMsg := "attempt %d to download file %s failed, retrying in %s: %v"
+		log.Printf(errMsg, attempt, key, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
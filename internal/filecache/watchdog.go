@@ -0,0 +1,121 @@
+package filecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var defaultWatchdog = watchdog{
+	readTimeout: 30 * time.Second,
+	minRate:     16 * 1024,
+	window:      30 * time.Second,
+}
+
+// watchdogTick is how often a download is inspected
+const watchdogTick = time.Second
+
+// Progress of a running download. Bytes are counted as received,
+// i.e. before decompression.
+type Progress struct {
+	Done  int64 `json:"done"`  // bytes received so far
+	Total int64 `json:"total"` // expected bytes; -1 if unknown
+}
+
+// progress is updated by the download and read by Progress
+type progress struct {
+	done  atomic.Int64
+	total atomic.Int64
+}
+
+// Progress reports the download of the key. False if the key is not
+// being downloaded, e.g. because it is stored already or still queued.
+func (fs *FileStore) Progress(key string) (Progress, bool) {
+	fs.cond.L.Lock()
+	defer fs.cond.L.Unlock()
+
+	j, exists := fs.jobs[key]
+	if !exists || !j.running || j.progress == nil {
+		return Progress{}, false
+	}
+	return Progress{Done: j.progress.done.Load(), Total: j.progress.total.Load()}, true
+}
+
+// SetWatchdog sets when a download is considered stalled and aborted:
+// no byte received for readTimeout, or less than minBytesPerSec on average
+// over the last window. A zero value disables the corresponding check.
+func (fs *FileStore) SetWatchdog(readTimeout time.Duration, minBytesPerSec int64, window time.Duration) {
+	fs.cond.L.Lock()
+	defer fs.cond.L.Unlock()
+	fs.watchdog = watchdog{readTimeout: readTimeout, minRate: minBytesPerSec, window: window}
+}
+
+// watchdog aborts downloads that do not make progress. It also covers
+// connecting and waiting for the first byte, whatever the fetcher.
+type watchdog struct {
+	readTimeout time.Duration // longest time without a received byte
+	minRate     int64         // bytes per second averaged over window
+	window      time.Duration
+}
+
+// watch cancels the download with a descriptive cause once it stalls.
+// The returned func stops watching.
+func (w watchdog) watch(cancel context.CancelCauseFunc, prog *progress) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(watchdogTick)
+		defer ticker.Stop()
+
+		start := time.Now()
+		lastByte, lastDone := start, prog.done.Load()
+		windowStart, windowDone := start, lastDone
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n := prog.done.Load()
+				if n != lastDone {
+					lastByte, lastDone = now, n
+				}
+				if w.readTimeout > 0 && now.Sub(lastByte) > w.readTimeout {
+					errMsg := "no data received for %s"
+					cancel(fmt.Errorf(errMsg, w.readTimeout))
+					return
+				}
+				if w.minRate > 0 && w.window > 0 && now.Sub(windowStart) >= w.window {
+					rate := float64(n-windowDone) / now.Sub(windowStart).Seconds()
+					if rate < float64(w.minRate) {
+						errMsg := "download is too slow: %.0f B/s over %s, need %d B/s"
+						cancel(fmt.Errorf(errMsg, rate, w.window, w.minRate))
+						return
+					}
+					windowStart, windowDone = now, n
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// cancelCause replaces a context error with the reason of cancellation
+func cancelCause(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// countingReader adds the number of bytes read to n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,215 @@
+// Package archivegath keeps the complete results of every job, with the
+// outputs as captured rather than trimmed for the messages, in a JSONL
+// archive of one file per day, e.g. for appeals.
+package archivegath
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/programme-lv/tester/api"
+)
+
+// Archive files are named <day><ext>, e.g. 2026-01-31.jsonl.zst, by UTC day
+const (
+	dayLayout = "2006-01-02"
+	plainExt  = ".jsonl"
+	zstdExt   = ".jsonl.zst"
+)
+
+// Record is a line of the archive: an api stream message of a job
+type Record struct {
+	Time     time.Time   `json:"time"`
+	EvalUuid string      `json:"eval_uuid"`
+	MsgType  api.MsgType `json:"msg_type"`
+
+	// Msg is the message as streamed, but with the outputs as captured.
+	// Its input and answer are left out; see Input and Answer.
+	Msg json.RawMessage `json:"msg"`
+
+	// Truncated is set if an output in Msg holds only the first MiB of
+	// what the program wrote; its runtime data has the original size
+	// as out_orig_size or err_orig_size.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Job is the request, on job_start. Inline test contents
+	// are replaced by their sha256.
+	Job *api.ExecReq `json:"job,omitempty"`
+
+	// Input and Answer identify the files of the test, on test_reach
+	Input  *TestFile `json:"input,omitempty"`
+	Answer *TestFile `json:"answer,omitempty"`
+}
+
+// TestFile identifies a test file by its sha256, or by its name in the test archive
+type TestFile struct {
+	Sha256  string `json:"sha256,omitempty"`
+	Archive string `json:"archive,omitempty"` // sha256 of the test archive
+	Name    string `json:"name,omitempty"`    // in the test archive
+}
+
+// Archive appends the records of finished jobs to the file of the day.
+// It is safe for concurrent use; each job is written at once.
+type Archive struct {
+	dir      string
+	compress bool // each job is a zstd frame of its own
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+	enc  *zstd.Encoder
+}
+
+// Open creates dir if it is missing. With compress, new files are zstd compressed.
+func Open(dir string, compress bool) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	a := &Archive{dir: dir, compress: compress}
+	if compress {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd encoder failed: %w", err)
+		}
+		a.enc = enc
+	}
+	return a, nil
+}
+
+// Append writes the records of a job and syncs the file
+func (a *Archive) Append(records []Record) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record: %w", err)
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.rotate(time.Now().UTC().Format(dayLayout)); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if a.enc != nil {
+		data = a.enc.EncodeAll(data, nil)
+	}
+	if _, err := a.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync archive: %w", err)
+	}
+	return nil
+}
+
+// rotate opens the file of the day, which may exist from an earlier run
+func (a *Archive) rotate(day string) error {
+	if a.file != nil && a.day == day {
+		return nil
+	}
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	ext := plainExt
+	if a.compress {
+		ext = zstdExt
+	}
+	f, err := os.OpenFile(filepath.Join(a.dir, day+ext), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	a.file, a.day = f, day
+	return nil
+}
+
+// Close closes the file of the day
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// Find returns the records of a job from all archive files in dir,
+// compressed or not, in the order they were written.
+func Find(dir string, evalUuid string) ([]Record, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+	var names []string
+	for _, de := range dirEntries {
+		if strings.HasSuffix(de.Name(), plainExt) || strings.HasSuffix(de.Name(), zstdExt) {
+			names = append(names, de.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var found []Record
+	for _, name := range names {
+		records, err := findInFile(filepath.Join(dir, name), evalUuid)
+		if err != nil {
+			return found, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		found = append(found, records...)
+	}
+	return found, nil
+}
+
+func findInFile(path string, evalUuid string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, zstdExt) {
+		d, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("zstd decoder failed: %w", err)
+		}
+		defer d.Close()
+		r = d
+	}
+
+	var found []Record
+	needle := []byte(evalUuid)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && bytes.Contains(line, needle) {
+			var rec Record
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr == nil && rec.EvalUuid == evalUuid {
+				found = append(found, rec)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return found, nil
+		}
+		if err != nil {
+			return found, err
+		}
+	}
+}
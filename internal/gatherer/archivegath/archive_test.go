@@ -0,0 +1,56 @@
+package archivegath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/programme-lv/tester/api"
+)
+
+func TestArchiveMarksTruncatedOutputs(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		archive, err := Open(dir, compress)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		content := "1\n"
+		req := api.ExecReq{
+			Uuid:  "00000000-0000-4000-8000-000000000000",
+			Tests: []api.Test{{In: api.File{Content: &content}, Ans: api.File{Content: &content}}},
+		}
+		g := New(archive, req)
+		g.StartJob("test")
+		g.ReachTest(1, []byte(content), []byte(content))
+		g.FinishTest(1, &api.RuntimeData{Stdout: "head", StdoutOrigSize: 5 << 20}, &api.RuntimeData{})
+		g.FinishTest(2, &api.RuntimeData{Stdout: "whole"}, nil)
+		g.FinishNoError()
+		if err := archive.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		records, err := Find(dir, req.Uuid)
+		if err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		var finished []Record
+		for _, r := range records {
+			if r.MsgType == api.FinishTestMsg {
+				finished = append(finished, r)
+			}
+			if r.MsgType == api.StartJobMsg && (r.Job == nil || r.Job.Tests[0].In.Content != nil) {
+				t.Errorf("compress=%v: job_start does not carry the request without contents", compress)
+			}
+		}
+		if len(records) != 5 || len(finished) != 2 {
+			t.Fatalf("compress=%v: %d records, %d test_finish, want 5 and 2", compress, len(records), len(finished))
+		}
+		if !finished[0].Truncated || finished[1].Truncated {
+			t.Errorf("compress=%v: truncated = %v, %v; want true, false", compress, finished[0].Truncated, finished[1].Truncated)
+		}
+		var msg api.FinishTest
+		if err := json.Unmarshal(finished[0].Msg, &msg); err != nil || msg.Submission.StdoutOrigSize != 5<<20 {
+			t.Errorf("compress=%v: original size not archived: %s", compress, finished[0].Msg)
+		}
+	}
+}
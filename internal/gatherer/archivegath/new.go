@@ -0,0 +1,145 @@
+package archivegath
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/programme-lv/tester/api"
+)
+
+// New creates a gatherer that collects the records of the job
+// and appends them to the archive once the job finishes.
+func New(archive *Archive, req api.ExecReq) *archiveGatherer {
+	return &archiveGatherer{
+		archive:  archive,
+		req:      req,
+		evalUuid: req.Uuid,
+	}
+}
+
+type archiveGatherer struct {
+	archive  *Archive
+	req      api.ExecReq
+	evalUuid string
+	records  []Record
+}
+
+func (a *archiveGatherer) add(msgType api.MsgType, msg any, rec Record) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to marshal message: %v", err)
+		return
+	}
+	rec.Time = time.Now()
+	rec.EvalUuid = a.evalUuid
+	rec.MsgType = msgType
+	rec.Msg = b
+	a.records = append(a.records, rec)
+}
+
+// finish appends the job to the archive
+func (a *archiveGatherer) finish(msg api.FinishJob) {
+	a.add(api.FinishJobMsg, msg, Record{})
+	if err := a.archive.Append(a.records); err != nil {
+		log.Printf("failed to archive job %s: %v", a.evalUuid, err)
+	}
+	a.records = nil
+}
+
+func (a *archiveGatherer) StartJob(systemInfo string) {
+	a.add(api.StartJobMsg, api.NewStartJob(a.evalUuid, systemInfo), Record{Job: archivedRequest(a.req)})
+}
+
+func (a *archiveGatherer) StartCompile() {
+	a.add(api.StartCompileMsg, api.NewStartCompile(a.evalUuid), Record{})
+}
+
+func (a *archiveGatherer) FinishCompile(data *api.RuntimeData) {
+	a.add(api.FinishCompileMsg, api.NewFinishCompile(a.evalUuid, data), Record{Truncated: truncated(data)})
+}
+
+func (a *archiveGatherer) ReachTest(testId int64, input []byte, answer []byte) {
+	in, ans := a.testFiles(testId)
+	a.add(api.ReachTestMsg, api.NewReachTest(a.evalUuid, testId, nil, nil), Record{Input: in, Answer: ans})
+}
+
+func (a *archiveGatherer) IgnoreTest(testId int64) {
+	a.add(api.IgnoreTestMsg, api.NewIgnoreTest(a.evalUuid, testId), Record{})
+}
+
+func (a *archiveGatherer) FinishTest(testId int64, subm *api.RuntimeData, chkr *api.RuntimeData) {
+	a.add(api.FinishTestMsg, api.NewFinishTest(a.evalUuid, testId, subm, chkr), Record{Truncated: truncated(subm, chkr)})
+}
+
+func (a *archiveGatherer) CompileError(msg string) {
+	a.finish(api.NewFinishJob(a.evalUuid, &msg, true, false))
+}
+
+func (a *archiveGatherer) InternalError(msg string) {
+	a.finish(api.NewFinishJob(a.evalUuid, &msg, false, true))
+}
+
+func (a *archiveGatherer) FinishNoError() {
+	a.finish(api.NewFinishJob(a.evalUuid, nil, false, false))
+}
+
+// truncated tells whether an output of the runs was cut when captured
+func truncated(runs ...*api.RuntimeData) bool {
+	for _, d := range runs {
+		if d != nil && (d.StdoutOrigSize > 0 || d.StderrOrigSize > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// testFiles identifies the input and answer of a test, numbered from 1
+func (a *archiveGatherer) testFiles(testId int64) (*TestFile, *TestFile) {
+	i := int(testId) - 1
+	if archive := a.req.Archive; archive != nil {
+		if i < 0 || i >= len(archive.Manifest) || archive.Sha256 == nil {
+			return nil, nil
+		}
+		entry := archive.Manifest[i]
+		return &TestFile{Archive: *archive.Sha256, Name: entry.In},
+			&TestFile{Archive: *archive.Sha256, Name: entry.Ans}
+	}
+	if i < 0 || i >= len(a.req.Tests) {
+		return nil, nil
+	}
+	test := a.req.Tests[i]
+	return &TestFile{Sha256: fileSha256(test.In)}, &TestFile{Sha256: fileSha256(test.Ans)}
+}
+
+// archivedRequest copies the request with inline test contents replaced by their sha256
+func archivedRequest(req api.ExecReq) *api.ExecReq {
+	tests := make([]api.Test, len(req.Tests))
+	for i, test := range req.Tests {
+		test.In, test.Ans = withoutContent(test.In), withoutContent(test.Ans)
+		tests[i] = test
+	}
+	req.Tests = tests
+	return &req
+}
+
+func withoutContent(f api.File) api.File {
+	if f.Content == nil {
+		return f
+	}
+	sha := fileSha256(f)
+	return api.File{Sha256: &sha, Url: f.Url}
+}
+
+func fileSha256(f api.File) string {
+	if f.Sha256 != nil {
+		return *f.Sha256
+	}
+	if f.Content != nil {
+		sum := sha256.Sum256([]byte(*f.Content))
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+// Package multigath fans the events of a job out to several gatherers,
+// e.g. to stream a job to NATS while archiving it to disk.
+package multigath
+
+import (
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+)
+
+// Sink is a gatherer fed by a multigath.Gatherer
+type Sink struct {
+	// Name tells the sink apart in logs
+	Name     string
+	Gatherer internal.ResultGatherer
+
+	// Buffer is how many events may wait for a slow gatherer, which is
+	// then called from a goroutine of its own. Once the buffer is full,
+	// further events are dropped, except for the last event of the job.
+	// With 0 the gatherer is called synchronously, e.g. a respbuilder
+	// whose response is read as soon as the job returns.
+	Buffer int
+
+	// Transform, if set, rewrites the events of this sink only, e.g. Truncate
+	Transform Transform
+}
+
+// Transform wraps a gatherer to rewrite the events passed to it
+type Transform func(internal.ResultGatherer) internal.ResultGatherer
+
+// Gatherer passes every event to each of its sinks in order. A sink that
+// panics is logged and receives no further events; the others go on.
+// Close must be called after the job to wait for buffered events.
+type Gatherer struct {
+	sinks []*sink
+}
+
+// New starts the sinks
+func New(sinks ...Sink) *Gatherer {
+	g := &Gatherer{}
+	for _, s := range sinks {
+		g.sinks = append(g.sinks, newSink(s))
+	}
+	return g
+}
+
+// Close waits until every sink has received its buffered events.
+// The gatherer must not be used afterwards.
+func (g *Gatherer) Close() {
+	for _, s := range g.sinks {
+		s.close()
+	}
+}
+
+func (g *Gatherer) each(call func(internal.ResultGatherer), last bool) {
+	for _, s := range g.sinks {
+		s.deliver(event{call: call, last: last})
+	}
+}
+
+func (g *Gatherer) StartJob(systemInfo string) {
+	g.each(func(r internal.ResultGatherer) { r.StartJob(systemInfo) }, false)
+}
+
+func (g *Gatherer) StartCompile() {
+	g.each(func(r internal.ResultGatherer) { r.StartCompile() }, false)
+}
+
+func (g *Gatherer) FinishCompile(data *api.RuntimeData) {
+	g.each(func(r internal.ResultGatherer) { r.FinishCompile(data) }, false)
+}
+
+func (g *Gatherer) ReachTest(testId int64, input []byte, answer []byte) {
+	g.each(func(r internal.ResultGatherer) { r.ReachTest(testId, input, answer) }, false)
+}
+
+func (g *Gatherer) IgnoreTest(testId int64) {
+	g.each(func(r internal.ResultGatherer) { r.IgnoreTest(testId) }, false)
+}
+
+func (g *Gatherer) FinishTest(testId int64, subm *api.RuntimeData, chkr *api.RuntimeData) {
+	g.each(func(r internal.ResultGatherer) { r.FinishTest(testId, subm, chkr) }, false)
+}
+
+func (g *Gatherer) CompileError(msg string) {
+	g.each(func(r internal.ResultGatherer) { r.CompileError(msg) }, true)
+}
+
+func (g *Gatherer) InternalError(msg string) {
+	g.each(func(r internal.ResultGatherer) { r.InternalError(msg) }, true)
+}
+
+func (g *Gatherer) FinishNoError() {
+	g.each(func(r internal.ResultGatherer) { r.FinishNoError() }, true)
+}
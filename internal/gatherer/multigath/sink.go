@@ -0,0 +1,88 @@
+package multigath
+
+import (
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/programme-lv/tester/internal"
+)
+
+// event is a call of a ResultGatherer method
+type event struct {
+	call func(internal.ResultGatherer)
+	last bool // finishes the job; never dropped
+}
+
+type sink struct {
+	name   string
+	gath   internal.ResultGatherer
+	events chan event    // nil if called synchronously
+	done   chan struct{} // closed once events are drained
+
+	failed  atomic.Bool // the gatherer panicked
+	dropped atomic.Int64
+}
+
+func newSink(s Sink) *sink {
+	gath := s.Gatherer
+	if s.Transform != nil {
+		gath = s.Transform(gath)
+	}
+	k := &sink{name: s.Name, gath: gath}
+	if s.Buffer > 0 {
+		k.events = make(chan event, s.Buffer)
+		k.done = make(chan struct{})
+		go k.run()
+	}
+	return k
+}
+
+func (k *sink) deliver(e event) {
+	if k.events == nil {
+		k.call(e)
+		return
+	}
+	if e.last {
+		k.events <- e
+		return
+	}
+	select {
+	case k.events <- e:
+	default:
+		if k.dropped.Add(1) == 1 {
+			log.Printf("gatherer %s is falling behind; dropping events", k.name)
+		}
+	}
+}
+
+func (k *sink) run() {
+	defer close(k.done)
+	for e := range k.events {
+		k.call(e)
+	}
+}
+
+func (k *sink) call(e event) {
+	if k.failed.Load() {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			k.failed.Store(true)
+			log.Printf("gatherer %s panicked and is disabled: %v\n%s", k.name, r, debug.Stack())
+		}
+	}()
+	e.call(k.gath)
+}
+
+func (k *sink) close() {
+	if k.events == nil {
+		return
+	}
+	close(k.events)
+	<-k.done
+	if n := k.dropped.Load(); n > 0 {
+		log.Printf("gatherer %s dropped %d events", k.name, n)
+	}
+}
@@ -0,0 +1,37 @@
+package multigath
+
+import (
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+	"github.com/programme-lv/tester/internal/gatherer/policygath"
+)
+
+// Truncate trims the inputs, answers and outputs passed to a sink to
+// height lines of width bytes, e.g. api.MaxRuntimeDataHeight and api.MaxRuntimeDataWidth.
+func Truncate(height int, width int) Transform {
+	return func(g internal.ResultGatherer) internal.ResultGatherer {
+		return &truncated{ResultGatherer: g, height: height, width: width}
+	}
+}
+
+type truncated struct {
+	internal.ResultGatherer
+	height int
+	width  int
+}
+
+func (t *truncated) FinishCompile(data *api.RuntimeData) {
+	t.ResultGatherer.FinishCompile(policygath.TrimRuntimeData(data, t.height, t.width))
+}
+
+func (t *truncated) ReachTest(testId int64, input []byte, answer []byte) {
+	t.ResultGatherer.ReachTest(testId,
+		[]byte(policygath.TrimStrToRect(string(input), t.height, t.width)),
+		[]byte(policygath.TrimStrToRect(string(answer), t.height, t.width)))
+}
+
+func (t *truncated) FinishTest(testId int64, subm *api.RuntimeData, chkr *api.RuntimeData) {
+	t.ResultGatherer.FinishTest(testId,
+		policygath.TrimRuntimeData(subm, t.height, t.width),
+		policygath.TrimRuntimeData(chkr, t.height, t.width))
+}
@@ -1,14 +1,37 @@
 package natsgath
 
 import (
+	"context"
+	"time"
+
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+// jsPublishTimeout bounds waiting for JetStream to store a message
+const jsPublishTimeout = 10 * time.Second
+
 // New creates a new NATS gatherer that streams responses to the given inbox subject.
 func New(nc *nats.Conn, evalUuid string, inbox string) *natsGatherer {
 	return &natsGatherer{
-		nc:       nc,
+		publish:  nc.Publish,
 		inbox:    inbox,
 		evalUuid: evalUuid,
 	}
 }
+
+// NewJetStream creates a gatherer that publishes responses to the subject
+// through JetStream, waiting until a stream has stored each of them,
+// so that late consumers can replay the results.
+func NewJetStream(js jetstream.JetStream, evalUuid string, subject string) *natsGatherer {
+	return &natsGatherer{
+		publish: func(subject string, data []byte) error {
+			ctx, cancel := context.WithTimeout(context.Background(), jsPublishTimeout)
+			defer cancel()
+			_, err := js.Publish(ctx, subject, data)
+			return err
+		},
+		inbox:    subject,
+		evalUuid: evalUuid,
+	}
+}
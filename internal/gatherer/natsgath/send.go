@@ -12,7 +12,7 @@ func (s *natsGatherer) send(msg interface{}) {
 		return
 	}
 
-	if err := s.nc.Publish(s.inbox, b); err != nil {
+	if err := s.publish(s.inbox, b); err != nil {
 		log.Printf("failed to publish message to NATS: %v", err)
 	}
 }
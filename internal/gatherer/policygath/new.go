@@ -0,0 +1,100 @@
+// Package policygath applies the visibility of the tests and the truncation
+// of a request to the events of its job, see api.Visibility.
+package policygath
+
+import (
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+)
+
+// New wraps g so that it only receives what the request allows to show.
+// An unknown visibility hides the test.
+func New(g internal.ResultGatherer, req api.ExecReq) internal.ResultGatherer {
+	height, width := Truncation(req)
+	return &policyGatherer{ResultGatherer: g, req: req, height: height, width: width}
+}
+
+// Truncation is the size outputs of the request are trimmed to
+func Truncation(req api.ExecReq) (height int, width int) {
+	if t := req.Truncation; t != nil && t.Height > 0 && t.Width > 0 {
+		return t.Height, t.Width
+	}
+	return api.MaxRuntimeDataHeight, api.MaxRuntimeDataWidth
+}
+
+type policyGatherer struct {
+	internal.ResultGatherer
+	req    api.ExecReq
+	height int
+	width  int
+}
+
+func (p *policyGatherer) FinishCompile(data *api.RuntimeData) {
+	p.ResultGatherer.FinishCompile(TrimRuntimeData(data, p.height, p.width))
+}
+
+func (p *policyGatherer) ReachTest(testId int64, input []byte, answer []byte) {
+	switch Visibility(p.req, testId) {
+	case api.VisibilityFull:
+	case api.VisibilityTruncated:
+		input = []byte(TrimStrToRect(string(input), p.height, p.width))
+		answer = []byte(TrimStrToRect(string(answer), p.height, p.width))
+	default:
+		input, answer = nil, nil
+	}
+	p.ResultGatherer.ReachTest(testId, input, answer)
+}
+
+func (p *policyGatherer) FinishTest(testId int64, subm *api.RuntimeData, chkr *api.RuntimeData) {
+	switch Visibility(p.req, testId) {
+	case api.VisibilityFull:
+	case api.VisibilityTruncated:
+		subm = TrimRuntimeData(subm, p.height, p.width)
+		chkr = TrimRuntimeData(chkr, p.height, p.width)
+	default:
+		// the output may echo the input, and the checker may quote the answer
+		subm = hideRuntimeData(subm)
+		chkr = hideRuntimeData(chkr)
+	}
+	p.ResultGatherer.FinishTest(testId, subm, chkr)
+}
+
+// Visibility of a test numbered from 1, truncated if not set
+func Visibility(req api.ExecReq, testId int64) api.Visibility {
+	i := int(testId) - 1
+	var v api.Visibility
+	if archive := req.Archive; archive != nil {
+		if i >= 0 && i < len(archive.Manifest) {
+			v = archive.Manifest[i].Visibility
+		}
+	} else if i >= 0 && i < len(req.Tests) {
+		v = req.Tests[i].Visibility
+	}
+	if v == "" {
+		return api.VisibilityTruncated
+	}
+	return v
+}
+
+// TrimRuntimeData returns a copy with the standard streams cut to height lines of width bytes
+func TrimRuntimeData(data *api.RuntimeData, height int, width int) *api.RuntimeData {
+	if data == nil {
+		return nil
+	}
+	trimmed := *data
+	trimmed.Stdin = TrimStrToRect(data.Stdin, height, width)
+	trimmed.Stdout = TrimStrToRect(data.Stdout, height, width)
+	trimmed.Stderr = TrimStrToRect(data.Stderr, height, width)
+	return &trimmed
+}
+
+func hideRuntimeData(data *api.RuntimeData) *api.RuntimeData {
+	if data == nil {
+		return nil
+	}
+	hidden := *data
+	hidden.Stdin, hidden.Stdout, hidden.Stderr = "", "", ""
+	hidden.StdoutRef, hidden.StderrRef = nil, nil
+	hidden.StdoutOrigSize, hidden.StderrOrigSize = 0, 0
+	return &hidden
+}
@@ -1,10 +1,12 @@
-package natsgath
+package policygath
 
 import (
 	"strings"
 )
 
-func trimStrToRect(s string, maxHeight int, maxWidth int) string {
+// TrimStrToRect keeps the first maxHeight lines of maxWidth bytes of s,
+// marking what was cut with "[...]"
+func TrimStrToRect(s string, maxHeight int, maxWidth int) string {
 	if s == "" {
 		return ""
 	}
@@ -1,22 +1,16 @@
 package sqsgath
 
 import (
-	"context"
-	"fmt"
-
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
-func NewSqsResponseQueueGatherer(evalUuid string, responseSqsUrl string) *sqsResQueueGatherer {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("eu-central-1"))
-	if err != nil {
-		panic(fmt.Sprintf("unable to load SDK config, %v", err))
-	}
-
+// NewSqsResponseQueueGatherer sends the stream messages of a job to an SQS
+// queue in batches. The job's last message is sent before its gatherer call returns.
+func NewSqsResponseQueueGatherer(sqsClient *sqs.Client, evalUuid string, responseSqsUrl string) *sqsResQueueGatherer {
 	return &sqsResQueueGatherer{
-		sqsClient: sqs.NewFromConfig(cfg),
+		sqsClient: sqsClient,
 		queueUrl:  responseSqsUrl,
 		evalUuid:  evalUuid,
+		fifo:      isFifo(responseSqsUrl),
 	}
 }
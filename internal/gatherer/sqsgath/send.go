@@ -1,26 +1,214 @@
 package sqsgath
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Message attributes of the responses. Messages of a job may arrive out of
+// order, except on FIFO queues; consumers restore it by eval_uuid and seq.
+const (
+	EvalUuidAttr = "eval_uuid"
+	SeqAttr      = "seq"      // position of the message within its job, from 1
+	EncodingAttr = "encoding" // EncodingZstd if the body is compressed
+	ChunkAttr    = "chunk"    // "<i>/<n>", from 1, if the body is split; join bodies of a seq in order
+)
+
+// EncodingZstd marks a body holding the base64 of the zstd compressed JSON message
+const EncodingZstd = "zstd+base64"
+
+const (
+	// maxMessageBytes is the SQS limit of a message, and of a batch, including attributes
+	maxMessageBytes = 256 * 1024
+	// attrReserveBytes is left for the attributes of a message
+	attrReserveBytes = 512
+	maxBodyBytes     = maxMessageBytes - attrReserveBytes
+	maxBatchEntries  = 10
+
+	// lingerTime is how long a message may wait for others to fill a batch
+	lingerTime = 100 * time.Millisecond
+
+	sendAttempts   = 5
+	initialBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
 )
 
 func (s *sqsResQueueGatherer) send(msg interface{}) {
 	b, err := json.Marshal(msg)
 	if err != nil {
-		log.Fatalf("failed to marshal message: %v", err)
+		log.Printf("failed to marshal message: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	for _, entry := range s.entries(b) {
+		size := entrySize(entry)
+		if len(s.pending) == maxBatchEntries || s.pendingBytes+size > maxMessageBytes {
+			s.flushLocked()
+		}
+		s.pending = append(s.pending, entry)
+		s.pendingBytes += size
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(lingerTime, s.flush)
+	}
+}
+
+// flush sends the pending messages. Called after the last message of a job.
+func (s *sqsResQueueGatherer) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *sqsResQueueGatherer) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.pending) == 0 {
+		return
+	}
+	s.sendBatch(s.pending)
+	s.pending = nil
+	s.pendingBytes = 0
+}
+
+// sendBatch retries failed entries with backoff. Entries that still
+// fail are logged and dropped; the job goes on either way.
+func (s *sqsResQueueGatherer) sendBatch(entries []types.SendMessageBatchRequestEntry) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		out, err := s.sqsClient.SendMessageBatch(context.TODO(), &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(s.queueUrl),
+			Entries:  entries,
+		})
+		if err == nil {
+			entries = retryable(entries, out.Failed)
+			if len(entries) == 0 {
+				return
+			}
+			err = fmt.Errorf("%d messages failed: %s", len(out.Failed), aws.ToString(out.Failed[0].Message))
+		}
+		if attempt == sendAttempts {
+			log.Printf("failed to send %d messages of %s after %d attempts: %v", len(entries), s.evalUuid, attempt, err)
+			return
+		}
+		log.Printf("failed to send messages of %s, retrying in %v: %v", s.evalUuid, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
 	}
+}
+
+// retryable returns the failed entries that are not the sender's fault
+func retryable(entries []types.SendMessageBatchRequestEntry, failed []types.BatchResultErrorEntry) []types.SendMessageBatchRequestEntry {
+	byId := make(map[string]types.SendMessageBatchRequestEntry, len(entries))
+	for _, e := range entries {
+		byId[aws.ToString(e.Id)] = e
+	}
+	var retry []types.SendMessageBatchRequestEntry
+	for _, f := range failed {
+		if f.SenderFault {
+			log.Printf("dropping message %s: %s: %s", aws.ToString(f.Id), aws.ToString(f.Code), aws.ToString(f.Message))
+			continue
+		}
+		retry = append(retry, byId[aws.ToString(f.Id)])
+	}
+	return retry
+}
 
-	_, err = s.sqsClient.SendMessage(context.TODO(), &sqs.SendMessageInput{
-		QueueUrl:    aws.String(s.queueUrl),
-		MessageBody: aws.String(string(b)),
-	})
+// entries turns a message into batch entries, compressing it if it is
+// too large for SQS and splitting it if that does not suffice
+func (s *sqsResQueueGatherer) entries(b []byte) []types.SendMessageBatchRequestEntry {
+	body := string(b)
+	encoding := ""
+	if len(body) > maxBodyBytes {
+		// even if it does not shrink, base64 can be split anywhere,
+		// unlike JSON with multi-byte characters
+		compressed, err := compress(b)
+		if err != nil {
+			log.Printf("failed to compress message: %v", err)
+			return nil
+		}
+		body, encoding = compressed, EncodingZstd
+	}
+
+	var chunks []string
+	for len(body) > maxBodyBytes {
+		chunks = append(chunks, body[:maxBodyBytes])
+		body = body[maxBodyBytes:]
+	}
+	chunks = append(chunks, body)
+
+	entries := make([]types.SendMessageBatchRequestEntry, len(chunks))
+	for i, chunk := range chunks {
+		attrs := map[string]types.MessageAttributeValue{
+			EvalUuidAttr: stringAttr(s.evalUuid),
+			SeqAttr:      {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(s.seq))},
+		}
+		if encoding != "" {
+			attrs[EncodingAttr] = stringAttr(encoding)
+		}
+		if len(chunks) > 1 {
+			attrs[ChunkAttr] = stringAttr(fmt.Sprintf("%d/%d", i+1, len(chunks)))
+		}
+		id := fmt.Sprintf("%d-%d", s.seq, i+1)
+		entries[i] = types.SendMessageBatchRequestEntry{
+			Id:                aws.String(id),
+			MessageBody:       aws.String(chunk),
+			MessageAttributes: attrs,
+		}
+		if s.fifo {
+			entries[i].MessageGroupId = aws.String(s.evalUuid)
+			entries[i].MessageDeduplicationId = aws.String(s.evalUuid + "-" + id)
+		}
+	}
+	return entries
+}
 
+func compress(b []byte) (string, error) {
+	var buf bytes.Buffer
+	enc, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
 	if err != nil {
-		log.Fatalf("failed to send message: %v", err)
+		return "", err
+	}
+	if _, err := enc.Write(b); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func stringAttr(v string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+}
+
+// entrySize is the size SQS counts towards its limits
+func entrySize(e types.SendMessageBatchRequestEntry) int {
+	size := len(aws.ToString(e.MessageBody))
+	for name, attr := range e.MessageAttributes {
+		size += len(name) + len(aws.ToString(attr.DataType)) + len(aws.ToString(attr.StringValue))
 	}
+	return size
+}
+
+func isFifo(queueUrl string) bool {
+	return strings.HasSuffix(queueUrl, ".fifo")
 }
@@ -0,0 +1,167 @@
+package sqsgath
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// bigMessage does not compress below the SQS limit, so it must be split
+func bigMessage(t *testing.T, n int) []byte {
+	t.Helper()
+	noise := make([]byte, n)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(map[string]string{"stdout": base64.StdEncoding.EncodeToString(noise)})
+	return b
+}
+
+func attr(e types.SendMessageBatchRequestEntry, name string) string {
+	return aws.ToString(e.MessageAttributes[name].StringValue)
+}
+
+// join restores a message from the entries of one seq, as consumers do
+func join(t *testing.T, entries []types.SendMessageBatchRequestEntry) []byte {
+	t.Helper()
+	var body string
+	for _, e := range entries {
+		body += aws.ToString(e.MessageBody)
+	}
+	if attr(entries[0], EncodingAttr) != EncodingZstd {
+		return []byte(body)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		t.Fatalf("chunks do not join into base64: %v", err)
+	}
+	d, _ := zstd.NewReader(nil)
+	defer d.Close()
+	b, err := d.DecodeAll(compressed, nil)
+	if err != nil {
+		t.Fatalf("chunks do not join into zstd: %v", err)
+	}
+	return b
+}
+
+func TestEntries(t *testing.T) {
+	s := &sqsResQueueGatherer{evalUuid: "00000000-0000-4000-8000-000000000000", fifo: true, seq: 7}
+
+	small := []byte(`{"msg_type":"start_job"}`)
+	entries := s.entries(small)
+	if len(entries) != 1 || aws.ToString(entries[0].MessageBody) != string(small) {
+		t.Fatalf("small message: %d entries, want it as is", len(entries))
+	}
+	e := entries[0]
+	if attr(e, EncodingAttr) != "" || attr(e, ChunkAttr) != "" || attr(e, SeqAttr) != "7" ||
+		attr(e, EvalUuidAttr) != s.evalUuid {
+		t.Errorf("small message attributes: %v", e.MessageAttributes)
+	}
+	if aws.ToString(e.MessageGroupId) != s.evalUuid || aws.ToString(e.MessageDeduplicationId) != s.evalUuid+"-7-1" {
+		t.Errorf("fifo ids: group %s, dedup %s", aws.ToString(e.MessageGroupId), aws.ToString(e.MessageDeduplicationId))
+	}
+
+	big := bigMessage(t, 600*1024)
+	entries = s.entries(big)
+	if len(entries) < 3 {
+		t.Fatalf("big message: %d entries, want it split", len(entries))
+	}
+	for i, e := range entries {
+		if want := fmt.Sprintf("%d/%d", i+1, len(entries)); attr(e, ChunkAttr) != want {
+			t.Errorf("chunk %d is labelled %q, want %q", i, attr(e, ChunkAttr), want)
+		}
+		if attr(e, SeqAttr) != "7" || attr(e, EncodingAttr) != EncodingZstd {
+			t.Errorf("chunk %d attributes: %v", i, e.MessageAttributes)
+		}
+		if len(aws.ToString(e.MessageBody)) > maxBodyBytes || entrySize(e) > maxMessageBytes {
+			t.Errorf("chunk %d takes %d bytes, over the limit", i, entrySize(e))
+		}
+	}
+	if !bytes.Equal(join(t, entries), big) {
+		t.Error("joined chunks differ from the message")
+	}
+}
+
+// fakeSQS records the SendMessageBatch calls it receives
+type fakeSQS struct {
+	mu      sync.Mutex
+	batches [][]types.SendMessageBatchRequestEntry
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var in sqs.SendMessageBatchInput
+	if err := json.Unmarshal(body, &in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.batches = append(f.batches, in.Entries)
+	f.mu.Unlock()
+
+	var ok []map[string]string
+	for _, e := range in.Entries {
+		ok = append(ok, map[string]string{"Id": aws.ToString(e.Id), "MessageId": aws.ToString(e.Id)})
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(map[string]any{"Successful": ok, "Failed": []any{}})
+}
+
+func TestSendBatches(t *testing.T) {
+	fake := &fakeSQS{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := sqs.New(sqs.Options{
+		Region:                           "us-east-1",
+		BaseEndpoint:                     aws.String(srv.URL),
+		Credentials:                      aws.AnonymousCredentials{},
+		DisableMessageChecksumValidation: true,
+	})
+	s := NewSqsResponseQueueGatherer(client, "00000000-0000-4000-8000-000000000000", srv.URL+"/responses")
+
+	// a split message in the middle of small ones
+	var sent [][]byte
+	for i := 0; i < 25; i++ {
+		b, _ := json.Marshal(map[string]int{"test": i})
+		if i == 12 {
+			b = bigMessage(t, 400*1024)
+		}
+		s.send(json.RawMessage(b))
+		sent = append(sent, b)
+	}
+	s.flush()
+
+	bySeq := make(map[int][]types.SendMessageBatchRequestEntry)
+	for i, batch := range fake.batches {
+		size := 0
+		for _, e := range batch {
+			size += entrySize(e)
+			seq, _ := strconv.Atoi(attr(e, SeqAttr))
+			bySeq[seq] = append(bySeq[seq], e)
+		}
+		if len(batch) > maxBatchEntries || size > maxMessageBytes {
+			t.Errorf("batch %d has %d entries of %d bytes, over the limits", i, len(batch), size)
+		}
+	}
+	if len(bySeq) != len(sent) {
+		t.Fatalf("received %d messages, want %d", len(bySeq), len(sent))
+	}
+	for i, want := range sent {
+		if got := join(t, bySeq[i+1]); !bytes.Equal(got, want) {
+			t.Errorf("message %d differs: %.40s...", i+1, got)
+		}
+	}
+}
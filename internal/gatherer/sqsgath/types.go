@@ -1,7 +1,11 @@
 package sqsgath
 
 import (
+	"sync"
+	"time"
+
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/programme-lv/tester/api"
 )
 
@@ -9,68 +13,37 @@ type sqsResQueueGatherer struct {
 	sqsClient *sqs.Client
 	queueUrl  string
 	evalUuid  string
+	fifo      bool // messages are grouped by job and deduplicated
+
+	mu           sync.Mutex
+	seq          int // of the last message
+	pending      []types.SendMessageBatchRequestEntry
+	pendingBytes int
+	timer        *time.Timer // flushes pending after lingerTime
 }
 
 func (s *sqsResQueueGatherer) FinishCompile(data *api.RuntimeData) {
-	msg := api.NewFinishCompile(
-		s.evalUuid,
-		trimRuntimeDataStrings(data, api.MaxRuntimeDataHeight, api.MaxRuntimeDataWidth),
-	)
+	msg := api.NewFinishCompile(s.evalUuid, data)
 	s.send(msg)
 }
 
 func (s *sqsResQueueGatherer) CompileError(msg string) {
 	s.send(api.NewFinishJob(s.evalUuid, &msg, true, false))
+	s.flush()
 }
 
 func (s *sqsResQueueGatherer) InternalError(msg string) {
 	s.send(api.NewFinishJob(s.evalUuid, &msg, false, true))
+	s.flush()
 }
 
 func (s *sqsResQueueGatherer) FinishNoError() {
 	s.send(api.NewFinishJob(s.evalUuid, nil, false, false))
-}
-
-func trimRuntimeDataStrings(data *api.RuntimeData, ioHeight int, ioWidth int) *api.RuntimeData {
-	if data == nil {
-		return nil
-	}
-	var stdin string = ""
-	if len(data.Stdin) > 0 {
-		stdin = string(data.Stdin)
-	}
-	var stdout string = ""
-	if len(data.Stdout) > 0 {
-		stdout = string(data.Stdout)
-	}
-	var stderr string = ""
-	if len(data.Stderr) > 0 {
-		stderr = string(data.Stderr)
-	}
-	return &api.RuntimeData{
-		Stdin:         trimStrToRect(stdin, ioHeight, ioWidth),
-		Stdout:        trimStrToRect(stdout, ioHeight, ioWidth),
-		Stderr:        trimStrToRect(stderr, ioHeight, ioWidth),
-		ExitCode:      data.ExitCode,
-		CpuMillis:     data.CpuMillis,
-		WallMillis:    data.WallMillis,
-		RamKiBytes:    data.RamKiBytes,
-		CtxSwV:        data.CtxSwV,
-		CtxSwF:        data.CtxSwF,
-		ExitSignal:    data.ExitSignal,
-		IsolateStatus: data.IsolateStatus,
-		IsolateMsg:    data.IsolateMsg,
-		CgOomKilled:   data.CgOomKilled,
-	}
+	s.flush()
 }
 
 func (s *sqsResQueueGatherer) FinishTest(testId int64, submission *api.RuntimeData, checker *api.RuntimeData) {
-	msg := api.NewFinishTest(
-		s.evalUuid,
-		testId,
-		trimRuntimeDataStrings(submission, api.MaxRuntimeDataHeight, api.MaxRuntimeDataWidth),
-		trimRuntimeDataStrings(checker, api.MaxRuntimeDataHeight, api.MaxRuntimeDataWidth),
-	)
+	msg := api.NewFinishTest(s.evalUuid, testId, submission, checker)
 	s.send(msg)
 }
 
@@ -91,17 +64,14 @@ func (s *sqsResQueueGatherer) StartJob(systemInfo string) {
 
 // ReachTest implements tester.EvalResGatherer.
 func (s *sqsResQueueGatherer) ReachTest(testId int64, input []byte, answer []byte) {
-	var inputStrPtr *string = nil
-	trimmedInput := trimStrToRect(string(input), api.MaxRuntimeDataHeight, api.MaxRuntimeDataWidth)
-	if trimmedInput != "" {
-		inputStr := trimmedInput
-		inputStrPtr = &inputStr
-	}
-	var answerStrPtr *string = nil
-	trimmedAnswer := trimStrToRect(string(answer), api.MaxRuntimeDataHeight, api.MaxRuntimeDataWidth)
-	if trimmedAnswer != "" {
-		answerStr := trimmedAnswer
-		answerStrPtr = &answerStr
+	s.send(api.NewReachTest(s.evalUuid, testId, strOrNil(input), strOrNil(answer)))
+}
+
+// strOrNil leaves out an empty input or answer, e.g. of a hidden test
+func strOrNil(b []byte) *string {
+	if len(b) == 0 {
+		return nil
 	}
-	s.send(api.NewReachTest(s.evalUuid, testId, inputStrPtr, answerStrPtr))
+	s := string(b)
+	return &s
 }
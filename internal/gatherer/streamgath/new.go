@@ -0,0 +1,15 @@
+package streamgath
+
+import "github.com/programme-lv/tester/api"
+
+// Sink receives the stream messages of a job in order,
+// e.g. to write them as Server-Sent Events or WebSocket frames.
+type Sink func(msgType api.MsgType, msg any)
+
+// New creates a gatherer that passes the api stream messages of a job to the sink.
+func New(evalUuid string, sink Sink) *streamGatherer {
+	return &streamGatherer{
+		sink:     sink,
+		evalUuid: evalUuid,
+	}
+}
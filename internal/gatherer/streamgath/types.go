@@ -0,0 +1,61 @@
+package streamgath
+
+import (
+	"github.com/programme-lv/tester/api"
+)
+
+type streamGatherer struct {
+	sink     Sink
+	evalUuid string
+}
+
+func (s *streamGatherer) FinishCompile(data *api.RuntimeData) {
+	msg := api.NewFinishCompile(s.evalUuid, data)
+	s.sink(api.FinishCompileMsg, msg)
+}
+
+func (s *streamGatherer) CompileError(msg string) {
+	s.sink(api.FinishJobMsg, api.NewFinishJob(s.evalUuid, &msg, true, false))
+}
+
+func (s *streamGatherer) InternalError(msg string) {
+	s.sink(api.FinishJobMsg, api.NewFinishJob(s.evalUuid, &msg, false, true))
+}
+
+func (s *streamGatherer) FinishNoError() {
+	s.sink(api.FinishJobMsg, api.NewFinishJob(s.evalUuid, nil, false, false))
+}
+
+func (s *streamGatherer) FinishTest(testId int64, submission *api.RuntimeData, checker *api.RuntimeData) {
+	msg := api.NewFinishTest(s.evalUuid, testId, submission, checker)
+	s.sink(api.FinishTestMsg, msg)
+}
+
+// IgnoreTest implements tester.EvalResGatherer.
+func (s *streamGatherer) IgnoreTest(testId int64) {
+	s.sink(api.IgnoreTestMsg, api.NewIgnoreTest(s.evalUuid, testId))
+}
+
+// StartCompile implements tester.EvalResGatherer.
+func (s *streamGatherer) StartCompile() {
+	s.sink(api.StartCompileMsg, api.NewStartCompile(s.evalUuid))
+}
+
+// StartJob implements tester.EvalResGatherer.
+func (s *streamGatherer) StartJob(systemInfo string) {
+	s.sink(api.StartJobMsg, api.NewStartJob(s.evalUuid, systemInfo))
+}
+
+// ReachTest implements tester.EvalResGatherer.
+func (s *streamGatherer) ReachTest(testId int64, input []byte, answer []byte) {
+	s.sink(api.ReachTestMsg, api.NewReachTest(s.evalUuid, testId, strOrNil(input), strOrNil(answer)))
+}
+
+// strOrNil leaves out an empty input or answer, e.g. of a hidden test
+func strOrNil(b []byte) *string {
+	if len(b) == 0 {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
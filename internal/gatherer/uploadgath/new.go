@@ -0,0 +1,68 @@
+package uploadgath
+
+import (
+	"context"
+	"log"
+
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+	"github.com/programme-lv/tester/internal/gatherer/policygath"
+)
+
+// New wraps g so that the outputs the request truncates are uploaded to
+// the store and referenced in the runtime data g receives. Outputs of
+// full tests are not trimmed, so they are not uploaded; those of hidden
+// tests are not uploaded either, as a link would reveal what the test
+// hides. If an upload fails, the output is passed on without a reference.
+func New(store *Store, g internal.ResultGatherer, req api.ExecReq) internal.ResultGatherer {
+	height, width := policygath.Truncation(req)
+	return &uploadGatherer{ResultGatherer: g, store: store, req: req, height: height, width: width}
+}
+
+type uploadGatherer struct {
+	internal.ResultGatherer
+	store  *Store
+	req    api.ExecReq
+	height int
+	width  int
+}
+
+func (u *uploadGatherer) FinishCompile(data *api.RuntimeData) {
+	u.ResultGatherer.FinishCompile(u.upload(data))
+}
+
+func (u *uploadGatherer) FinishTest(testId int64, subm *api.RuntimeData, chkr *api.RuntimeData) {
+	if policygath.Visibility(u.req, testId) == api.VisibilityTruncated {
+		subm, chkr = u.upload(subm), u.upload(chkr)
+	}
+	u.ResultGatherer.FinishTest(testId, subm, chkr)
+}
+
+// upload returns a copy of data referencing its truncated outputs
+func (u *uploadGatherer) upload(data *api.RuntimeData) *api.RuntimeData {
+	if data == nil {
+		return nil
+	}
+	linked := *data
+	linked.StdoutRef = u.put(data.Stdout, data.StdoutOrigSize)
+	linked.StderrRef = u.put(data.Stderr, data.StderrOrigSize)
+	return &linked
+}
+
+// put uploads an output as captured; origSize is the size of the whole
+// output if only its head was captured, 0 otherwise
+func (u *uploadGatherer) put(output string, origSize int64) *api.OutputRef {
+	if policygath.TrimStrToRect(output, u.height, u.width) == output {
+		return nil
+	}
+	ref, err := u.store.Put(context.Background(), output)
+	if err != nil {
+		log.Printf("failed to upload output of job %s: %v", u.req.Uuid, err)
+		return nil
+	}
+	if origSize > ref.Size {
+		ref.Truncated = true
+		ref.OrigSize = origSize
+	}
+	return ref
+}
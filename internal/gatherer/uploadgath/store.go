@@ -0,0 +1,86 @@
+// Package uploadgath uploads the outputs that stream messages truncate
+// to S3-compatible object storage and links them in the messages.
+// The uploads hold the outputs as captured, which is the first MiB of
+// a longer output; its reference is then marked as truncated.
+package uploadgath
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/programme-lv/tester/api"
+)
+
+// uploadTimeout bounds the upload of a single output
+const uploadTimeout = 30 * time.Second
+
+// Store keeps outputs zstd compressed in a bucket under <prefix><sha256>.zst,
+// so an output is stored once however many jobs produce it
+type Store struct {
+	client  *s3.Client
+	bucket  string
+	prefix  string
+	baseURL string
+	enc     *zstd.Encoder
+}
+
+// NewStore creates a store. The references link baseURL/<key> if baseURL
+// is set, e.g. a CDN in front of the bucket, and s3://bucket/key otherwise.
+func NewStore(client *s3.Client, bucket string, prefix string, baseURL string) (*Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket must not be empty")
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		errMsg := "failed to create zstd writer: %w"
+		return nil, fmt.Errorf(errMsg, err)
+	}
+	return &Store{
+		client:  client,
+		bucket:  bucket,
+		prefix:  prefix,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		enc:     enc,
+	}, nil
+}
+
+// Put uploads an output and returns a reference to it
+func (s *Store) Put(ctx context.Context, output string) (*api.OutputRef, error) {
+	sum := sha256.Sum256([]byte(output))
+	sha := hex.EncodeToString(sum[:])
+	key := s.prefix + sha + ".zst"
+
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	// EncodeAll may be called concurrently
+	data := s.enc.EncodeAll([]byte(output), nil)
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/zstd"),
+	})
+	if err != nil {
+		errMsg := "failed to put s3://%s/%s: %w"
+		return nil, fmt.Errorf(errMsg, s.bucket, key, err)
+	}
+
+	url := fmt.Sprintf("s3://%s/%s", s.bucket, key)
+	if s.baseURL != "" {
+		url = s.baseURL + "/" + key
+	}
+	return &api.OutputRef{
+		Sha256:   sha,
+		Size:     int64(len(output)),
+		Url:      url,
+		Encoding: api.OutputEncodingZstd,
+	}, nil
+}
@@ -0,0 +1,41 @@
+package wsgath
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+	"github.com/programme-lv/tester/internal/gatherer/streamgath"
+)
+
+// writeTimeout bounds sending one message to a slow client
+const writeTimeout = 10 * time.Second
+
+// New creates a gatherer that sends the api stream messages of a job as
+// JSON text frames over the WebSocket connection. Several jobs may share
+// the connection; their messages are told apart by eval_uuid.
+// ctx is that of the connection.
+func New(ctx context.Context, conn *websocket.Conn, evalUuid string) internal.ResultGatherer {
+	return streamgath.New(evalUuid, func(msgType api.MsgType, msg any) {
+		Send(ctx, conn, msg)
+	})
+}
+
+// Send writes a message as a JSON text frame.
+func Send(ctx context.Context, conn *websocket.Conn, msg any) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to marshal message: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	if err := conn.Write(ctx, websocket.MessageText, b); err != nil {
+		log.Printf("failed to send message over WebSocket: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Package httpexec serves jobs over HTTP for local development and
+// small deployments, see `tester listen http`.
+package httpexec
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+	"github.com/programme-lv/tester/internal/filecache"
+	"github.com/programme-lv/tester/internal/gatherer/respbuilder"
+	"github.com/programme-lv/tester/internal/gatherer/streamgath"
+)
+
+// maxRequestBytes bounds the JSON body of a job; tests may be inlined
+const maxRequestBytes = 64 << 20
+
+// Executor runs a job, reporting its progress to the gatherer
+type Executor interface {
+	ExecTests(ctx context.Context, gath internal.ResultGatherer, req api.ExecReq) error
+}
+
+// FileProgress reports running downloads of test files
+type FileProgress interface {
+	Progress(key string) (filecache.Progress, bool)
+}
+
+// Handler serves
+//
+//	POST /exec         an api.ExecReq, answered with an api.ExecResponse once done
+//	POST /exec/stream  the same, answered with the api stream messages as Server-Sent Events
+//	GET  /ws           a WebSocket carrying job_submit and job_cancel messages
+//	                   and the api stream messages of the submitted jobs
+//	GET  /files/{sha256}/progress
+//	                   the download of a test file, see SetFileProgress
+//
+// At most concurrency jobs run at once; others wait for a slot.
+type Handler struct {
+	exec    Executor
+	slots   chan struct{}
+	tokens  []string
+	origins []string // see SetOriginPatterns
+	mux     *http.ServeMux
+}
+
+// New creates a handler. If tokens is empty, every request is accepted;
+// otherwise requests must carry "Authorization: Bearer <token>" with one of them.
+// Browsers cannot set headers on WebSocket requests, so /ws also accepts
+// the token as the access_token query parameter.
+func New(exec Executor, concurrency int, tokens []string) *Handler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	h := &Handler{
+		exec:   exec,
+		slots:  make(chan struct{}, concurrency),
+		tokens: tokens,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("POST /exec", h.handleExec)
+	h.mux.HandleFunc("POST /exec/stream", h.handleStream)
+	h.mux.HandleFunc("GET /ws", h.handleWS)
+	return h
+}
+
+// SetFileProgress lets clients poll the download of the test files of their
+// jobs. It answers 404 once a file is stored, or while it is queued.
+func (h *Handler) SetFileProgress(p FileProgress) {
+	h.mux.HandleFunc("GET /files/{sha256}/progress", func(w http.ResponseWriter, r *http.Request) {
+		progress, ok := p.Progress(r.PathValue("sha256"))
+		if !ok {
+			http.Error(w, "file is not being downloaded", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(progress); err != nil {
+			log.Printf("failed to write progress: %v", err)
+		}
+	})
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if len(h.tokens) == 0 {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.URL.Path == "/ws" {
+		got, ok = r.URL.Query().Get("access_token"), true
+	}
+	if !ok || got == "" {
+		return false
+	}
+	for _, token := range h.tokens {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) handleExec(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.acquire(r.Context()) {
+		return // the client has gone
+	}
+	defer h.release()
+
+	log.Printf("received request with uuid: %s", req.Uuid)
+	builder := respbuilder.New(req.Uuid)
+	if err := h.exec.ExecTests(r.Context(), builder, req); err != nil {
+		log.Printf("error executing tests: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(builder.Response()); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := newEventWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer events.close()
+
+	if !h.acquire(r.Context()) {
+		return
+	}
+	defer h.release()
+
+	log.Printf("received streaming request with uuid: %s", req.Uuid)
+	gatherer := streamgath.New(req.Uuid, events.send)
+	if err := h.exec.ExecTests(r.Context(), gatherer, req); err != nil {
+		log.Printf("error executing tests: %v", err)
+	}
+}
+
+// acquire waits for a free job slot. False if ctx is done first.
+func (h *Handler) acquire(ctx context.Context) bool {
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (h *Handler) release() {
+	<-h.slots
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request) (api.ExecReq, error) {
+	var req api.ExecReq
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err := dec.Decode(&req); err != nil {
+		return req, fmt.Errorf("bad json: %w", err)
+	}
+	if req.Uuid == "" {
+		req.Uuid = uuid.New().String()
+	}
+	if err := uuid.Validate(req.Uuid); err != nil {
+		return req, fmt.Errorf("invalid uuid %q: %w", req.Uuid, err)
+	}
+	return req, nil
+}
@@ -0,0 +1,21 @@
+package httpexec
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExecRejectsBadRequests(t *testing.T) {
+	h := New(panicExecutor{}, 1, nil)
+	for _, path := range []string{"/exec", "/exec/stream"} {
+		for _, body := range []string{`{"uuid": "x"}`, `{"uuid": `} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("POST %s %s: status %d, want %d", path, body, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package httpexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/programme-lv/tester/api"
+)
+
+// keepaliveInterval keeps proxies from closing a stream while
+// the job waits for a slot or for its tests to download
+const keepaliveInterval = 15 * time.Second
+
+// eventWriter writes stream messages as Server-Sent Events,
+// naming each event by its message type
+type eventWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	closed  bool // guarded by mu
+	stop    chan struct{}
+}
+
+func newEventWriter(w http.ResponseWriter) (*eventWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported by the connection")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	e := &eventWriter{w: w, flusher: flusher, stop: make(chan struct{})}
+	go e.keepalive()
+	return e, nil
+}
+
+func (e *eventWriter) send(msgType api.MsgType, msg any) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to marshal message: %v", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", msgType, b); err != nil {
+		return // the client has gone; the job notices by its context
+	}
+	e.flusher.Flush()
+}
+
+func (e *eventWriter) keepalive() {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.mu.Lock()
+			if !e.closed {
+				_, _ = fmt.Fprint(e.w, ": keepalive\n\n")
+				e.flusher.Flush()
+			}
+			e.mu.Unlock()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// close stops the keepalive, as the response must not
+// be written to once the handler returns
+func (e *eventWriter) close() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	close(e.stop)
+}
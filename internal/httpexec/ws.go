@@ -0,0 +1,153 @@
+package httpexec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal/gatherer/wsgath"
+)
+
+var (
+	errCancelled    = errors.New("cancelled by client")
+	errDisconnected = errors.New("client disconnected")
+)
+
+// SetOriginPatterns allows WebSocket connections from pages of other
+// origins, e.g. "ide.programme.lv". Same-origin pages are always allowed.
+func (h *Handler) SetOriginPatterns(patterns []string) {
+	h.origins = patterns
+}
+
+// handleWS runs jobs submitted over a WebSocket connection. The client
+// sends job_submit and job_cancel messages; the worker answers with the
+// stream messages of every job, which may run concurrently.
+func (h *Handler) handleWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: h.origins})
+	if err != nil {
+		log.Printf("failed to accept WebSocket connection: %v", err)
+		return
+	}
+	conn.SetReadLimit(maxRequestBytes)
+
+	ctx, disconnect := context.WithCancelCause(r.Context())
+	defer disconnect(errDisconnected)
+
+	s := &wsSession{h: h, conn: conn, ctx: ctx, jobs: make(map[string]context.CancelCauseFunc)}
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			break
+		}
+		s.handle(data)
+	}
+
+	disconnect(errDisconnected)
+	s.wg.Wait()
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// wsSession is the state of one WebSocket connection
+type wsSession struct {
+	h    *Handler
+	conn *websocket.Conn
+	ctx  context.Context
+
+	mu   sync.Mutex
+	jobs map[string]context.CancelCauseFunc // running jobs by uuid
+	wg   sync.WaitGroup
+}
+
+func (s *wsSession) handle(data []byte) {
+	var header api.Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		s.fail("unknown", fmt.Sprintf("bad json: %v", err))
+		return
+	}
+
+	switch header.MsgType {
+	case api.SubmitJobMsg:
+		var msg api.SubmitJob
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.fail(header.EvalUuid, fmt.Sprintf("bad json: %v", err))
+			return
+		}
+		s.submit(msg)
+	case api.CancelJobMsg:
+		s.mu.Lock()
+		cancel, ok := s.jobs[header.EvalUuid]
+		s.mu.Unlock()
+		if !ok {
+			log.Printf("cannot cancel job %s: not running", header.EvalUuid)
+			return
+		}
+		cancel(errCancelled)
+	default:
+		s.fail(header.EvalUuid, fmt.Sprintf("unknown message type %q", header.MsgType))
+	}
+}
+
+func (s *wsSession) submit(msg api.SubmitJob) {
+	req := msg.Job
+	req.Uuid = msg.EvalUuid
+	if req.Uuid == "" {
+		req.Uuid = uuid.New().String()
+	}
+	if err := uuid.Validate(req.Uuid); err != nil {
+		s.fail(req.Uuid, fmt.Sprintf("invalid eval_uuid: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	if _, running := s.jobs[req.Uuid]; running {
+		s.mu.Unlock()
+		s.fail(req.Uuid, "a job with this uuid is already running")
+		return
+	}
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	s.jobs[req.Uuid] = cancel
+	s.mu.Unlock()
+
+	log.Printf("received WebSocket request with uuid: %s", req.Uuid)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.jobs, req.Uuid)
+			s.mu.Unlock()
+			cancel(nil)
+		}()
+		// net/http only recovers the panics of its own goroutines
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("job %s panicked: %v\n%s", req.Uuid, r, debug.Stack())
+				s.fail(req.Uuid, fmt.Sprintf("internal error: %v", r))
+			}
+		}()
+
+		if !s.h.acquire(ctx) {
+			s.fail(req.Uuid, fmt.Sprintf("job cancelled: %v", context.Cause(ctx)))
+			return
+		}
+		defer s.h.release()
+
+		gatherer := wsgath.New(s.ctx, s.conn, req.Uuid)
+		if err := s.h.exec.ExecTests(ctx, gatherer, req); err != nil {
+			log.Printf("error executing tests: %v", err)
+		}
+	}()
+}
+
+// fail finishes a job that could not be run with an internal error
+func (s *wsSession) fail(evalUuid string, msg string) {
+	wsgath.Send(s.ctx, s.conn, api.NewFinishJob(evalUuid, &msg, false, true))
+}
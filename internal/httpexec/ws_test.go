@@ -0,0 +1,62 @@
+package httpexec
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
+)
+
+// panicExecutor fails the way a bug deep in the tester would
+type panicExecutor struct{}
+
+func (panicExecutor) ExecTests(ctx context.Context, gath internal.ResultGatherer, req api.ExecReq) error {
+	panic("boom")
+}
+
+func TestWSRejectsBadUuidAndSurvivesPanics(t *testing.T) {
+	srv := httptest.NewServer(New(panicExecutor{}, 1, nil))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	cases := []struct {
+		uuid   string
+		reason string
+	}{
+		{"x", "invalid eval_uuid"},
+		{"tester.results.>", "invalid eval_uuid"},
+		{"00000000-0000-4000-8000-000000000000", "internal error: boom"},
+	}
+	for _, c := range cases {
+		submit := api.SubmitJob{Header: api.NewHeader(c.uuid, api.SubmitJobMsg)}
+		data, _ := json.Marshal(submit)
+		if err := conn.Write(ctx, websocket.MessageText, data); err != nil {
+			t.Fatalf("failed to submit %q: %v", c.uuid, err)
+		}
+		_, reply, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("no reply to %q: %v", c.uuid, err)
+		}
+		var finish api.FinishJob
+		if err := json.Unmarshal(reply, &finish); err != nil {
+			t.Fatalf("bad reply to %q: %v", c.uuid, err)
+		}
+		if finish.MsgType != api.FinishJobMsg || !finish.InternalError || finish.ErrorMessage == nil ||
+			!strings.Contains(*finish.ErrorMessage, c.reason) {
+			t.Errorf("reply to %q: %s, want a job_finish with %q", c.uuid, reply, c.reason)
+		}
+	}
+}
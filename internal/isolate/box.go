@@ -2,11 +2,14 @@ package isolate
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/programme-lv/tester/internal/sandbox"
 	"github.com/programme-lv/tester/internal/xdg"
 )
 
@@ -37,13 +40,25 @@ func (box *Box) Close() error {
 }
 
 func (box *Box) Command(
-	command string, constraints *Constraints) (*Cmd, error) {
+	command string, constraints *sandbox.Constraints) (sandbox.Cmd, error) {
+	return box.command(command, constraints, sandbox.Streams{})
+}
+
+// CommandWithFiles uses isolate's --stdin/--stdout/--stderr redirection.
+// The paths are relative to the box directory.
+func (box *Box) CommandWithFiles(
+	command string, constraints *sandbox.Constraints, files sandbox.Streams) (sandbox.Cmd, error) {
+	return box.command(command, constraints, files)
+}
+
+func (box *Box) command(
+	command string, constraints *sandbox.Constraints, files sandbox.Streams) (sandbox.Cmd, error) {
 
 	var isolateCmd *Cmd = &Cmd{}
 	if constraints != nil {
 		isolateCmd.Constraints = *constraints
 	} else {
-		isolateCmd.Constraints = DefaultConstraints()
+		isolateCmd.Constraints = sandbox.DefaultConstraints()
 	}
 
 	tempFilePath, err := newTempIsolateFilePath()
@@ -57,10 +72,20 @@ func (box *Box) Command(
 	args = append(args, "--cg")
 	args = append(args, fmt.Sprintf("--box-id=%d", box.id))
 
-	args = append(args, isolateCmd.Constraints.ToArgs()...)
+	args = append(args, constraintArgs(isolateCmd.Constraints)...)
 
 	args = append(args, fmt.Sprintf("--meta=%s", isolateCmd.metaFilePath))
 
+	if files.Stdin != "" {
+		args = append(args, fmt.Sprintf("--stdin=%s", files.Stdin))
+	}
+	if files.Stdout != "" {
+		args = append(args, fmt.Sprintf("--stdout=%s", files.Stdout))
+	}
+	if files.Stderr != "" {
+		args = append(args, fmt.Sprintf("--stderr=%s", files.Stderr))
+	}
+
 	args = append(args, "--env=HOME=/box")
 	args = append(args, "--env=PATH")
 
@@ -131,6 +156,34 @@ func (box *Box) AddFile(path string, content []byte) error {
 	return nil
 }
 
+func (box *Box) AddFileFrom(path string, r io.Reader) error {
+	path = filepath.Join(box.path, "box", path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0777)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// umask may have stripped bits from the mode passed to OpenFile
+	return os.Chmod(path, 0777)
+}
+
+func (box *Box) LinkFile(path string, src string) error {
+	return sandbox.LinkReadOnly(src, filepath.Join(box.path, "box", path))
+}
+
+// OpenFile opens a file written by the sandboxed program. Symlinks are not
+// followed: the program could point them anywhere on the host.
+func (box *Box) OpenFile(path string) (io.ReadCloser, error) {
+	path = filepath.Join(box.path, "box", path)
+	return os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+}
+
 func (box *Box) GetFile(path string) ([]byte, error) {
 	path = filepath.Join(box.path, "box", path)
 	return os.ReadFile(path)
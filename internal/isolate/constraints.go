@@ -2,59 +2,41 @@ package isolate
 
 import (
 	"fmt"
-)
-
-type Constraints struct {
-	CpuTimeLimInSec      float64
-	ExtraCpuTimeLimInSec float64
-	WallTimeLimInSec     float64
-	MemoryLimitInKB      int64
-	MaxProcesses         int64
-	MaxOpenFiles         int64
-}
 
-func DefaultConstraints() Constraints {
-	return Constraints{
-		CpuTimeLimInSec:      10.0,
-		ExtraCpuTimeLimInSec: 0.5,
-		WallTimeLimInSec:     20.0,
-		MemoryLimitInKB:      1 * 1024 * 1024, // 1 GB
-		MaxProcesses:         256,
-		MaxOpenFiles:         256,
-	}
-}
+	"github.com/programme-lv/tester/internal/sandbox"
+)
 
-func (constraints *Constraints) ToArgs() []string {
+func constraintArgs(constraints sandbox.Constraints) []string {
 	return []string{
-		constraints.MemLimArg(),
-		constraints.CpuTimeLimArg(),
-		constraints.ExtraCpuTimeLimArg(),
-		constraints.WallTimeLimArg(),
-		constraints.MaxProcessesArg(),
-		constraints.MaxOpenFilesArg(),
+		memLimArg(constraints),
+		cpuTimeLimArg(constraints),
+		extraCpuTimeLimArg(constraints),
+		wallTimeLimArg(constraints),
+		maxProcessesArg(constraints),
+		maxOpenFilesArg(constraints),
 	}
 }
 
-func (constraints *Constraints) MemLimArg() string {
+func memLimArg(constraints sandbox.Constraints) string {
 	return fmt.Sprintf("--cg-mem=%d", constraints.MemoryLimitInKB)
 }
 
-func (constraints *Constraints) CpuTimeLimArg() string {
+func cpuTimeLimArg(constraints sandbox.Constraints) string {
 	return fmt.Sprintf("--time=%f", constraints.CpuTimeLimInSec)
 }
 
-func (constraints *Constraints) ExtraCpuTimeLimArg() string {
+func extraCpuTimeLimArg(constraints sandbox.Constraints) string {
 	return fmt.Sprintf("--extra-time=%f", constraints.ExtraCpuTimeLimInSec)
 }
 
-func (constraints *Constraints) WallTimeLimArg() string {
+func wallTimeLimArg(constraints sandbox.Constraints) string {
 	return fmt.Sprintf("--wall-time=%f", constraints.WallTimeLimInSec)
 }
 
-func (constraints *Constraints) MaxProcessesArg() string {
+func maxProcessesArg(constraints sandbox.Constraints) string {
 	return fmt.Sprintf("--processes=%d", constraints.MaxProcesses)
 }
 
-func (constraints *Constraints) MaxOpenFilesArg() string {
+func maxOpenFilesArg(constraints sandbox.Constraints) string {
 	return fmt.Sprintf("--open-files=%d", constraints.MaxOpenFiles)
 }
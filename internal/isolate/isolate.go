@@ -5,6 +5,8 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+
+	"github.com/programme-lv/tester/internal/sandbox"
 )
 
 var once sync.Once
@@ -25,7 +27,8 @@ func GetInstance() *Isolate {
 	return instance
 }
 
-func (i *Isolate) NewBox() (*Box, error) {
+// NewBox initialises the lowest free box id. Isolate implements sandbox.Sandbox.
+func (i *Isolate) NewBox() (sandbox.Box, error) {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 
@@ -49,7 +52,7 @@ func (i *Isolate) NewBox() (*Box, error) {
 	return newIsolateBox(i, id, path), nil
 }
 
-func NewBox() (*Box, error) {
+func NewBox() (sandbox.Box, error) {
 	return GetInstance().NewBox()
 }
 
@@ -109,3 +112,18 @@ func (i *Isolate) eraseBox(boxId int) error {
 
 	return nil
 }
+
+// Version returns the version reported by `isolate --version`,
+// e.g. "2.0" for the first line "The process isolator 2.0".
+func Version() (string, error) {
+	out, err := exec.Command("isolate", "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to run isolate --version: %w", err)
+	}
+	firstLine, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected isolate --version output: %q", string(out))
+	}
+	return fields[len(fields)-1], nil
+}
@@ -4,37 +4,24 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
-)
 
-type Metrics struct {
-	CpuMillis    int64
-	WallMillis   int64
-	MaxRssKb     int64
-	CswVoluntary int64
-	CswForced    int64
-	CgMemKb      int64
-	CgOomKilled  bool
-	ExitCode     int64
-	Status       *string
-	Message      *string
-	Killed       int64
-	ExitSig      *int64
-	FullReport   string
-}
+	"github.com/programme-lv/tester/internal/sandbox"
+)
 
-func parseMetaFile(metaFileBytes []byte) (*Metrics, error) {
-	metrics := &Metrics{}
+func parseMetaFile(metaFileBytes []byte) (*sandbox.Metrics, error) {
+	metrics := &sandbox.Metrics{}
 	metrics.FullReport = string(metaFileBytes)
 	for _, line := range strings.Split(string(metaFileBytes), "\n") {
 		if line == "" {
 			continue
 		}
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid meta file line: %s", line)
+		// values (e.g. message) may contain colons themselves
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			slog.Warn("malformed meta file line", slog.String("line", line))
+			continue
 		}
 
-		key, value := parts[0], parts[1]
 		if err := parseLine(key, value, metrics); err != nil {
 			return nil, fmt.Errorf("error parsing meta file: %w", err)
 		}
@@ -42,7 +29,7 @@ func parseMetaFile(metaFileBytes []byte) (*Metrics, error) {
 	return metrics, nil
 }
 
-func parseLine(key, value string, metrics *Metrics) error {
+func parseLine(key, value string, metrics *sandbox.Metrics) error {
 	switch key {
 	case "time":
 		var timeSec float64
@@ -69,7 +56,11 @@ func parseLine(key, value string, metrics *Metrics) error {
 	case "status":
 		metrics.Status = &value
 	case "killed":
-		return sscanfErr(fmt.Sscanf(value, "%d", &metrics.Killed))
+		var killed int64
+		if err := sscanfErr(fmt.Sscanf(value, "%d", &killed)); err != nil {
+			return err
+		}
+		metrics.Killed = killed == 1
 	case "message":
 		metrics.Message = &value
 	case "exitsig":
@@ -87,8 +78,12 @@ func parseLine(key, value string, metrics *Metrics) error {
 	case "":
 		// ignore
 	default:
-		slog.Warn("unknown meta file line", slog.String("line", key+":"+value))
-		return fmt.Errorf("unknown meta file line: %s", key+":"+value)
+		// newer isolate versions add fields; keep them instead of failing
+		slog.Debug("unknown meta file line", slog.String("line", key+":"+value))
+		if metrics.Extra == nil {
+			metrics.Extra = make(map[string]string)
+		}
+		metrics.Extra[key] = value
 	}
 	return nil
 }
@@ -129,3 +124,14 @@ cg-mem:38248
 status:TO
 message:Time limit exceeded
 */
+
+/*
+time:0.000
+time-wall:0.001
+max-rss:1024
+csw-voluntary:1
+csw-forced:0
+cg-mem:1024
+status:XX
+message:execve("./a"): No such file or directory
+*/
@@ -0,0 +1,66 @@
+package isolate
+
+import "testing"
+
+func TestParseMetaFile(t *testing.T) {
+	meta := "time:0.115\n" +
+		"time-wall:0.125\n" +
+		"max-rss:18444\n" +
+		"csw-voluntary:1597\n" +
+		"csw-forced:28\n" +
+		"cg-mem:38248\n" +
+		"cg-oom-killed:1\n" +
+		"exitsig:9\n" +
+		"killed:1\n" +
+		"status:SG\n" +
+		"message:Caught fatal signal 9: killed\n" +
+		"cg-enabled:1\n" +
+		"future-field:a:b\n"
+
+	m, err := parseMetaFile([]byte(meta))
+	if err != nil {
+		t.Fatalf("parseMetaFile: %v", err)
+	}
+	if m.CpuMillis != 115 || m.WallMillis != 125 || m.MaxRssKb != 18444 ||
+		m.CswVoluntary != 1597 || m.CswForced != 28 || m.CgMemKb != 38248 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if !m.Killed || !m.CgOomKilled {
+		t.Errorf("killed = %v, cg-oom-killed = %v; want both", m.Killed, m.CgOomKilled)
+	}
+	if m.ExitSig == nil || *m.ExitSig != 9 {
+		t.Errorf("exitsig = %v, want 9", m.ExitSig)
+	}
+	if m.Status == nil || *m.Status != "SG" {
+		t.Errorf("status = %v, want SG", m.Status)
+	}
+	// values may contain colons
+	if m.Message == nil || *m.Message != "Caught fatal signal 9: killed" {
+		t.Errorf("message = %v", m.Message)
+	}
+	if len(m.Extra) != 2 || m.Extra["cg-enabled"] != "1" || m.Extra["future-field"] != "a:b" {
+		t.Errorf("extra = %v, want the unknown keys", m.Extra)
+	}
+	if m.FullReport != meta {
+		t.Errorf("full report = %q", m.FullReport)
+	}
+}
+
+func TestParseMetaFileNormalExit(t *testing.T) {
+	meta := "time:0.112\ntime-wall:0.103\nmax-rss:18984\nkilled:0\nexitcode:3\nmalformed\n"
+	m, err := parseMetaFile([]byte(meta))
+	if err != nil {
+		t.Fatalf("parseMetaFile: %v", err)
+	}
+	if m.ExitCode != 3 || m.Killed || m.Status != nil || m.ExitSig != nil || m.Extra != nil {
+		t.Errorf("metrics = %+v, want exit code 3 and nothing else set", m)
+	}
+}
+
+func TestParseMetaFileBadValue(t *testing.T) {
+	for _, meta := range []string{"time:fast\n", "killed:yes\n", "exitcode:\n"} {
+		if m, err := parseMetaFile([]byte(meta)); err == nil {
+			t.Errorf("parseMetaFile(%q) = %+v, want an error", meta, m)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+
+	"github.com/programme-lv/tester/internal/sandbox"
 )
 
 type Cmd struct {
@@ -14,7 +16,7 @@ type Cmd struct {
 	stderr       io.ReadCloser
 	started      bool
 	metaFilePath string
-	Constraints  Constraints
+	Constraints  sandbox.Constraints
 }
 
 func (process *Cmd) String() string {
@@ -67,7 +69,7 @@ func (process *Cmd) Start() error {
 	return process.cmd.Start()
 }
 
-func (process *Cmd) Wait() (*Metrics, error) {
+func (process *Cmd) Wait() (*sandbox.Metrics, error) {
 	if !process.started {
 		panic("process should be started before waiting")
 	}
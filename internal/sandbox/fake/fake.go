@@ -0,0 +1,435 @@
+// Package fake implements sandbox.Sandbox with plain child processes.
+//
+// It needs neither root nor isolate, so the tester flows (compilation,
+// checker, interactor) can be exercised on any Linux machine. Limits are
+// simulated: the wall time limit kills the process group, cpu time and
+// memory are compared against rusage after exit. Statuses that are hard
+// to provoke on purpose (TO, SG, XX) can be injected per command.
+// It is NOT a security boundary.
+package fake
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/programme-lv/tester/internal/sandbox"
+)
+
+type Sandbox struct {
+	dir string // parent directory of box directories
+
+	mu     sync.Mutex
+	faults []fault
+}
+
+// fault forces a status on every command containing match
+type fault struct {
+	match   string
+	status  string
+	message string
+}
+
+// New creates a fake sandbox keeping its boxes under dir.
+func New(dir string) (*Sandbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return &Sandbox{dir: dir}, nil
+}
+
+// Inject makes every later command whose text contains match finish with
+// the given isolate status ("TO", "SG", "XX", "RE") without being run.
+func (s *Sandbox) Inject(match string, status string, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{match: match, status: status, message: message})
+}
+
+// ClearFaults removes all injected statuses.
+func (s *Sandbox) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+func (s *Sandbox) faultFor(command string) *fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.faults {
+		if strings.Contains(command, s.faults[i].match) {
+			f := s.faults[i]
+			return &f
+		}
+	}
+	return nil
+}
+
+func (s *Sandbox) NewBox() (sandbox.Box, error) {
+	path, err := os.MkdirTemp(s.dir, "box-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create box directory: %w", err)
+	}
+	return &Box{sandbox: s, path: path}, nil
+}
+
+type Box struct {
+	sandbox *Sandbox
+	path    string
+}
+
+func (box *Box) Path() string {
+	return box.path
+}
+
+func (box *Box) Close() error {
+	return os.RemoveAll(box.path)
+}
+
+func (box *Box) Command(command string, constraints *sandbox.Constraints) (sandbox.Cmd, error) {
+	return box.CommandWithFiles(command, constraints, sandbox.Streams{})
+}
+
+func (box *Box) CommandWithFiles(command string, constraints *sandbox.Constraints, files sandbox.Streams) (sandbox.Cmd, error) {
+	c := sandbox.DefaultConstraints()
+	if constraints != nil {
+		c = *constraints
+	}
+
+	goCmd := exec.Command("/usr/bin/bash", "-c", command)
+	goCmd.Dir = box.path
+	goCmd.Env = []string{"HOME=" + box.path, "PATH=" + os.Getenv("PATH")}
+	goCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	return &Cmd{
+		cmd:         goCmd,
+		command:     command,
+		dir:         box.path,
+		files:       files,
+		fault:       box.sandbox.faultFor(command),
+		Constraints: c,
+	}, nil
+}
+
+func (box *Box) AddFile(path string, content []byte) error {
+	return os.WriteFile(filepath.Join(box.path, path), content, 0777)
+}
+
+func (box *Box) AddFileFrom(path string, r io.Reader) error {
+	f, err := os.OpenFile(filepath.Join(box.path, path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0777)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (box *Box) LinkFile(path string, src string) error {
+	return sandbox.LinkReadOnly(src, filepath.Join(box.path, path))
+}
+
+func (box *Box) OpenFile(path string) (io.ReadCloser, error) {
+	return os.OpenFile(filepath.Join(box.path, path), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+}
+
+func (box *Box) GetFile(path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(box.path, path))
+}
+
+func (box *Box) HasFile(path string) bool {
+	_, err := os.Stat(filepath.Join(box.path, path))
+	return !os.IsNotExist(err)
+}
+
+type Cmd struct {
+	cmd     *exec.Cmd
+	command string
+	dir     string
+	files   sandbox.Streams
+	fault   *fault
+
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+
+	started  bool
+	start    time.Time
+	timedOut bool
+	timer    *time.Timer
+	mu       sync.Mutex
+
+	Constraints sandbox.Constraints
+}
+
+func (process *Cmd) String() string {
+	return process.cmd.String()
+}
+
+func (process *Cmd) Start() error {
+	if process.started {
+		panic("process should not be started twice")
+	}
+	process.started = true
+	process.start = time.Now()
+
+	if process.fault != nil {
+		// nothing is run; behave like a process that reads and writes nothing
+		process.stdin = discardCloser{}
+		process.stdout = io.NopCloser(bytes.NewReader(nil))
+		process.stderr = io.NopCloser(bytes.NewReader(nil))
+		return nil
+	}
+
+	// childEnds are closed once the child holds its own copies,
+	// parentEnds are kept for Stdin, Stdout and Stderr
+	var childEnds, parentEnds []*os.File
+	fail := func(err error) error {
+		closeAll(childEnds)
+		closeAll(parentEnds)
+		return err
+	}
+
+	// like isolate, open redirected streams relative to the box
+	var stdinFile, stdoutFile, stderrFile *os.File
+	if process.files.Stdin != "" {
+		f, err := os.Open(filepath.Join(process.dir, process.files.Stdin))
+		if err != nil {
+			return fail(err)
+		}
+		childEnds = append(childEnds, f)
+		stdinFile = f
+	}
+	if process.files.Stdout != "" {
+		f, err := os.Create(filepath.Join(process.dir, process.files.Stdout))
+		if err != nil {
+			return fail(err)
+		}
+		childEnds = append(childEnds, f)
+		stdoutFile = f
+	}
+	if process.files.Stderr != "" {
+		f, err := os.Create(filepath.Join(process.dir, process.files.Stderr))
+		if err != nil {
+			return fail(err)
+		}
+		childEnds = append(childEnds, f)
+		stderrFile = f
+	}
+
+	// os.Pipe instead of cmd.StdoutPipe: Wait must not close our read ends
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return fail(err)
+	}
+	childEnds, parentEnds = append(childEnds, stdinR), append(parentEnds, stdinW)
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return fail(err)
+	}
+	childEnds, parentEnds = append(childEnds, stdoutW), append(parentEnds, stdoutR)
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		return fail(err)
+	}
+	childEnds, parentEnds = append(childEnds, stderrW), append(parentEnds, stderrR)
+
+	process.cmd.Stdin = stdinR
+	process.cmd.Stdout = stdoutW
+	process.cmd.Stderr = stderrW
+	if stdinFile != nil {
+		process.cmd.Stdin = stdinFile
+	}
+	if stdoutFile != nil {
+		process.cmd.Stdout = stdoutFile
+	}
+	if stderrFile != nil {
+		process.cmd.Stderr = stderrFile
+	}
+
+	if err := process.cmd.Start(); err != nil {
+		return fail(err)
+	}
+	closeAll(childEnds)
+	process.stdin = stdinW
+	process.stdout = stdoutR
+	process.stderr = stderrR
+
+	wall := time.Duration(process.Constraints.WallTimeLimInSec * float64(time.Second))
+	process.timer = time.AfterFunc(wall, func() {
+		process.mu.Lock()
+		process.timedOut = true
+		process.mu.Unlock()
+		_ = syscall.Kill(-process.cmd.Process.Pid, syscall.SIGKILL)
+	})
+	return nil
+}
+
+func (process *Cmd) Wait() (*sandbox.Metrics, error) {
+	if !process.started {
+		panic("process should be started before waiting")
+	}
+
+	if process.fault != nil {
+		return injectedMetrics(process.fault, process.Constraints), nil
+	}
+
+	err := process.cmd.Wait()
+	process.timer.Stop()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+	}
+
+	state := process.cmd.ProcessState
+	metrics := &sandbox.Metrics{
+		CpuMillis:  (state.UserTime() + state.SystemTime()).Milliseconds(),
+		WallMillis: time.Since(process.start).Milliseconds(),
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		metrics.MaxRssKb = rusage.Maxrss
+		metrics.CgMemKb = rusage.Maxrss
+		metrics.CswVoluntary = rusage.Nvcsw
+		metrics.CswForced = rusage.Nivcsw
+	}
+
+	process.mu.Lock()
+	timedOut := process.timedOut
+	process.mu.Unlock()
+
+	cpuLimitMs := int64(process.Constraints.CpuTimeLimInSec * 1000)
+	ws, _ := state.Sys().(syscall.WaitStatus)
+	switch {
+	case timedOut:
+		setStatus(metrics, "TO", "Time limit exceeded (wall clock)")
+		metrics.Killed = true
+	case metrics.CpuMillis > cpuLimitMs:
+		setStatus(metrics, "TO", "Time limit exceeded")
+		metrics.Killed = true
+	case metrics.CgMemKb > process.Constraints.MemoryLimitInKB:
+		sig := int64(syscall.SIGKILL)
+		metrics.ExitSig = &sig
+		metrics.CgOomKilled = true
+		setStatus(metrics, "SG", "Caught fatal signal 9")
+	case ws.Signaled():
+		sig := int64(ws.Signal())
+		metrics.ExitSig = &sig
+		setStatus(metrics, "SG", fmt.Sprintf("Caught fatal signal %d", sig))
+	case state.ExitCode() != 0:
+		metrics.ExitCode = int64(state.ExitCode())
+		setStatus(metrics, "RE", fmt.Sprintf("Exited with error status %d", metrics.ExitCode))
+	}
+	metrics.FullReport = fullReport(metrics)
+	return metrics, nil
+}
+
+func (process *Cmd) Stdin() io.WriteCloser {
+	if process.stdin == nil {
+		panic("process should be started before retrieving stdin")
+	}
+	return process.stdin
+}
+
+func (process *Cmd) Stdout() io.ReadCloser {
+	if process.stdout == nil {
+		panic("process should be started before retrieving stdout")
+	}
+	return process.stdout
+}
+
+func (process *Cmd) Stderr() io.ReadCloser {
+	if process.stderr == nil {
+		panic("process should be started before retrieving stderr")
+	}
+	return process.stderr
+}
+
+func injectedMetrics(f *fault, c sandbox.Constraints) *sandbox.Metrics {
+	metrics := &sandbox.Metrics{}
+	message := f.message
+	switch f.status {
+	case "TO":
+		// isolate kills the program once it uses up the extra time too
+		metrics.CpuMillis = int64((c.CpuTimeLimInSec + c.ExtraCpuTimeLimInSec) * 1000)
+		metrics.WallMillis = metrics.CpuMillis
+		metrics.Killed = true
+		if message == "" {
+			message = "Time limit exceeded"
+		}
+	case "SG":
+		sig := int64(syscall.SIGSEGV)
+		metrics.ExitSig = &sig
+		if message == "" {
+			message = "Caught fatal signal 11"
+		}
+	case "RE":
+		metrics.ExitCode = 1
+		if message == "" {
+			message = "Exited with error status 1"
+		}
+	case "XX":
+		if message == "" {
+			message = "Internal error of the sandbox"
+		}
+	}
+	setStatus(metrics, f.status, message)
+	metrics.FullReport = fullReport(metrics)
+	return metrics
+}
+
+func setStatus(metrics *sandbox.Metrics, status string, message string) {
+	metrics.Status = &status
+	metrics.Message = &message
+}
+
+// fullReport renders metrics the way isolate writes its meta file
+func fullReport(m *sandbox.Metrics) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "time:%.3f\n", float64(m.CpuMillis)/1000)
+	fmt.Fprintf(&b, "time-wall:%.3f\n", float64(m.WallMillis)/1000)
+	fmt.Fprintf(&b, "max-rss:%d\n", m.MaxRssKb)
+	fmt.Fprintf(&b, "csw-voluntary:%d\n", m.CswVoluntary)
+	fmt.Fprintf(&b, "csw-forced:%d\n", m.CswForced)
+	fmt.Fprintf(&b, "cg-mem:%d\n", m.CgMemKb)
+	if m.CgOomKilled {
+		b.WriteString("cg-oom-killed:1\n")
+	}
+	if m.ExitSig != nil {
+		fmt.Fprintf(&b, "exitsig:%d\n", *m.ExitSig)
+	} else {
+		fmt.Fprintf(&b, "exitcode:%d\n", m.ExitCode)
+	}
+	if m.Status != nil {
+		fmt.Fprintf(&b, "status:%s\n", *m.Status)
+	}
+	if m.Message != nil {
+		fmt.Fprintf(&b, "message:%s\n", *m.Message)
+	}
+	if m.Killed {
+		b.WriteString("killed:1\n")
+	}
+	return b.String()
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+type discardCloser struct{}
+
+func (discardCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (discardCloser) Close() error                { return nil }
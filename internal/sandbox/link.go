@@ -0,0 +1,38 @@
+package sandbox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// LinkReadOnly hardlinks src to dst. The link shares the cached file's
+// owner and mode, so a sandboxed program (running as another user) can read
+// but not modify it. Across filesystems, where hardlinks are impossible,
+// it falls back to a streamed copy with mode 0444.
+func LinkReadOnly(src string, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,89 @@
+package sandbox
+
+import "io"
+
+// Sandbox hands out isolated boxes for running untrusted programs.
+// The production backend is isolate; see internal/sandbox/fake for tests.
+type Sandbox interface {
+	NewBox() (Box, error)
+}
+
+// Box is a private working directory in which commands are run
+type Box interface {
+	Command(command string, constraints *Constraints) (Cmd, error)
+	// CommandWithFiles redirects the standard streams to files in the box
+	// instead of pipes, so that neither the Go scheduler nor pipe back-pressure
+	// affect the measured wall time
+	CommandWithFiles(command string, constraints *Constraints, files Streams) (Cmd, error)
+
+	AddFile(path string, content []byte) error
+	AddFileFrom(path string, r io.Reader) error
+	// LinkFile makes the host file src visible in the box as a read-only
+	// file without copying it, e.g. a test from the file cache
+	LinkFile(path string, src string) error
+	GetFile(path string) ([]byte, error)
+	OpenFile(path string) (io.ReadCloser, error)
+	HasFile(path string) bool
+
+	Close() error
+}
+
+// Cmd is a single command prepared inside a box
+type Cmd interface {
+	Start() error
+	Wait() (*Metrics, error)
+
+	Stdin() io.WriteCloser
+	Stdout() io.ReadCloser
+	Stderr() io.ReadCloser
+
+	String() string
+}
+
+// Streams names files in the box used as standard streams of a command.
+// An empty name keeps the corresponding pipe.
+type Streams struct {
+	Stdin  string
+	Stdout string
+	Stderr string
+}
+
+type Constraints struct {
+	CpuTimeLimInSec      float64
+	ExtraCpuTimeLimInSec float64
+	WallTimeLimInSec     float64
+	MemoryLimitInKB      int64
+	MaxProcesses         int64
+	MaxOpenFiles         int64
+}
+
+func DefaultConstraints() Constraints {
+	return Constraints{
+		CpuTimeLimInSec:      10.0,
+		ExtraCpuTimeLimInSec: 0.5,
+		WallTimeLimInSec:     20.0,
+		MemoryLimitInKB:      1 * 1024 * 1024, // 1 GB
+		MaxProcesses:         256,
+		MaxOpenFiles:         256,
+	}
+}
+
+// Metrics mirror the fields of an isolate meta file
+type Metrics struct {
+	CpuMillis    int64
+	WallMillis   int64
+	MaxRssKb     int64
+	CswVoluntary int64
+	CswForced    int64
+	CgMemKb      int64
+	CgOomKilled  bool
+	ExitCode     int64
+	Status       *string
+	Message      *string
+	Killed       bool
+	ExitSig      *int64
+	FullReport   string
+
+	// Extra holds meta file keys this version does not know about
+	Extra map[string]string
+}
@@ -0,0 +1,230 @@
+// Package spool shares jobs between workers through a directory,
+// for judging without a broker. Producers drop requests into incoming/;
+// a worker claims one by renaming it into processing/ and leaves the
+// outcome in done/ or failed/. Rename is atomic, so any number of workers
+// may share the spool as long as it is on a single filesystem.
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	IncomingDir   = "incoming"
+	ProcessingDir = "processing"
+	DoneDir       = "done"
+	FailedDir     = "failed"
+)
+
+// Request files are picked up by these extensions. Producers should write
+// a file under another name, e.g. with a leading dot, and rename it when complete.
+var requestExts = []string{".json", ".json.zst"}
+
+type Spool struct {
+	root  string
+	owner string // claim suffix of this process: <hostname>.<pid>
+}
+
+// Open creates the spool directories below root if they are missing.
+func Open(root string) (*Spool, error) {
+	for _, dir := range []string{IncomingDir, ProcessingDir, DoneDir, FailedDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+	host = strings.ReplaceAll(host, ".", "_")
+	return &Spool{root: root, owner: host + "." + strconv.Itoa(os.Getpid())}, nil
+}
+
+func (s *Spool) dir(name string) string {
+	return filepath.Join(s.root, name)
+}
+
+// Job is a request claimed by this worker
+type Job struct {
+	Name string // file name of the request in incoming/
+	path string // of the claimed file in processing/
+	s    *Spool
+}
+
+// Claim takes the oldest request from incoming/. Returns nil if there is none.
+// Requests claimed by other workers meanwhile are skipped.
+func (s *Spool) Claim() (*Job, error) {
+	dirEntries, err := os.ReadDir(s.dir(IncomingDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read incoming directory: %w", err)
+	}
+
+	type candidate struct {
+		name  string
+		mtime int64
+	}
+	var candidates []candidate
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() || !isRequest(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue // claimed meanwhile
+		}
+		candidates = append(candidates, candidate{de.Name(), info.ModTime().UnixNano()})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].mtime < candidates[j].mtime
+	})
+
+	for _, c := range candidates {
+		claimed := filepath.Join(s.dir(ProcessingDir), c.name+"."+s.owner)
+		err := os.Rename(filepath.Join(s.dir(IncomingDir), c.name), claimed)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // another worker was faster
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim %s: %w", c.name, err)
+		}
+		return &Job{Name: c.name, path: claimed, s: s}, nil
+	}
+	return nil, nil
+}
+
+// Recover moves requests back to incoming/ that were claimed by processes
+// of this host that no longer run, e.g. after a crash. Claims of other
+// hosts are left alone, as it cannot be told whether they are alive.
+func (s *Spool) Recover() (int, error) {
+	dirEntries, err := os.ReadDir(s.dir(ProcessingDir))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read processing directory: %w", err)
+	}
+	host, _, _ := strings.Cut(s.owner, ".")
+
+	recovered := 0
+	for _, de := range dirEntries {
+		name, pid, ok := parseClaim(de.Name(), host)
+		if !ok || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		err := os.Rename(filepath.Join(s.dir(ProcessingDir), de.Name()), filepath.Join(s.dir(IncomingDir), name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return recovered, fmt.Errorf("failed to recover %s: %w", name, err)
+		}
+		if err == nil {
+			recovered++
+		}
+	}
+	return recovered, nil
+}
+
+// Read returns the request, decompressed if it is zstd compressed.
+func (j *Job) Read() ([]byte, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", j.Name, err)
+	}
+	if !strings.HasSuffix(j.Name, ".zst") {
+		return data, nil
+	}
+	d, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("zstd decoder failed: %w", err)
+	}
+	defer d.Close()
+	data, err = io.ReadAll(d)
+	if err != nil {
+		return nil, fmt.Errorf("zstd decode failed: %w", err)
+	}
+	return data, nil
+}
+
+// Done moves the request to done/ next to its outputs, which are written
+// as <name>.<suffix> for every suffix, e.g. "response.json".
+func (j *Job) Done(outputs map[string][]byte) error {
+	return j.finish(DoneDir, outputs)
+}
+
+// Fail moves the request and its outputs to failed/.
+func (j *Job) Fail(outputs map[string][]byte) error {
+	return j.finish(FailedDir, outputs)
+}
+
+func (j *Job) finish(dir string, outputs map[string][]byte) error {
+	base := j.Name
+	for _, ext := range requestExts {
+		if b, ok := strings.CutSuffix(j.Name, ext); ok {
+			base = b
+		}
+	}
+
+	// outputs appear complete: written in processing/, then renamed
+	for suffix, data := range outputs {
+		tmp := filepath.Join(j.s.dir(ProcessingDir), "."+base+"."+suffix+"."+j.s.owner)
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s of %s: %w", suffix, j.Name, err)
+		}
+		if err := os.Rename(tmp, filepath.Join(j.s.dir(dir), base+"."+suffix)); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to move %s of %s: %w", suffix, j.Name, err)
+		}
+	}
+	if err := os.Rename(j.path, filepath.Join(j.s.dir(dir), j.Name)); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", j.Name, dir, err)
+	}
+	return nil
+}
+
+func isRequest(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, ext := range requestExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClaim splits "<name>.<host>.<pid>" for claims of the given host
+func parseClaim(claim string, host string) (string, int, bool) {
+	rest, pidStr, ok := cutLast(claim, ".")
+	if !ok {
+		return "", 0, false
+	}
+	name, claimHost, ok := cutLast(rest, ".")
+	if !ok || claimHost != host || !isRequest(name) {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return name, pid, true
+}
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
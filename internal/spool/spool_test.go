@@ -0,0 +1,141 @@
+package spool
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func writeFile(t *testing.T, path string, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaimOldestRequest(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	incoming := s.dir(IncomingDir)
+	now := time.Now()
+	enc, _ := zstd.NewWriter(nil)
+	writeFile(t, filepath.Join(incoming, "b.json.zst"), string(enc.EncodeAll([]byte(`{"b":1}`), nil)), now.Add(-2*time.Minute))
+	enc.Close()
+	writeFile(t, filepath.Join(incoming, "a.json"), `{"a":1}`, now.Add(-time.Minute))
+	// a request still being written and a file that is no request
+	writeFile(t, filepath.Join(incoming, ".c.json"), `{}`, now.Add(-time.Hour))
+	writeFile(t, filepath.Join(incoming, "notes.txt"), `{}`, now.Add(-time.Hour))
+
+	var claimed []string
+	for {
+		job, err := s.Claim()
+		if err != nil {
+			t.Fatalf("Claim: %v", err)
+		}
+		if job == nil {
+			break
+		}
+		data, err := job.Read()
+		if err != nil {
+			t.Fatalf("Read %s: %v", job.Name, err)
+		}
+		claimed = append(claimed, job.Name+" "+string(data))
+		if err := job.Done(map[string][]byte{"response.json": []byte("ok")}); err != nil {
+			t.Fatalf("Done %s: %v", job.Name, err)
+		}
+	}
+	if want := []string{`b.json.zst {"b":1}`, `a.json {"a":1}`}; strings.Join(claimed, ", ") != strings.Join(want, ", ") {
+		t.Errorf("claimed %q, want %q", claimed, want)
+	}
+
+	for _, name := range []string{"a.json", "a.response.json", "b.json.zst", "b.response.json"} {
+		if _, err := os.Stat(filepath.Join(s.dir(DoneDir), name)); err != nil {
+			t.Errorf("%s is not in done/: %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(s.dir(ProcessingDir)); len(entries) != 0 {
+		t.Errorf("%d files left in processing/", len(entries))
+	}
+}
+
+func TestFail(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	writeFile(t, filepath.Join(s.dir(IncomingDir), "bad.json"), `{`, time.Now())
+	job, err := s.Claim()
+	if err != nil || job == nil {
+		t.Fatalf("Claim = %v, %v", job, err)
+	}
+	if err := job.Fail(map[string][]byte{"error.txt": []byte("bad json")}); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir(FailedDir), "bad.error.txt"))
+	if err != nil || string(data) != "bad json" {
+		t.Errorf("error.txt = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir(FailedDir), "bad.json")); err != nil {
+		t.Errorf("request is not in failed/: %v", err)
+	}
+}
+
+// deadPid returns the pid of a process that has exited
+func deadPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestRecover(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	host, _, _ := strings.Cut(s.owner, ".")
+	processing := s.dir(ProcessingDir)
+	claims := map[string]bool{ // claim name: recovered
+		"dead.json." + host + "." + strconv.Itoa(deadPid(t)):       true,
+		"dead.json.zst." + host + "." + strconv.Itoa(deadPid(t)):   true,
+		"mine.json." + s.owner:                                     false,
+		"alive.json." + host + ".1":                                false,
+		"other.json.otherhost." + strconv.Itoa(deadPid(t)):         false,
+		".dead.response.json." + host + "." + strconv.Itoa(123456): false,
+	}
+	for claim := range claims {
+		writeFile(t, filepath.Join(processing, claim), `{}`, time.Now())
+	}
+
+	n, err := s.Recover()
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("recovered %d claims, want 2", n)
+	}
+	for claim, recovered := range claims {
+		_, err := os.Stat(filepath.Join(processing, claim))
+		if recovered == (err == nil) {
+			t.Errorf("%s: recovered = %v, want %v", claim, err != nil, recovered)
+		}
+	}
+	for _, name := range []string{"dead.json", "dead.json.zst"} {
+		if _, err := os.Stat(filepath.Join(s.dir(IncomingDir), name)); err != nil {
+			t.Errorf("%s is not back in incoming/: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package syscheck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/programme-lv/tester/internal/isolate"
+)
+
+// Level is the outcome of a single environment check
+type Level string
+
+const (
+	OK   Level = "OK"
+	Warn Level = "WARN"
+	Fail Level = "FAIL"
+)
+
+// Result of a single environment check
+type Result struct {
+	Name   string
+	Level  Level
+	Detail string
+}
+
+// Config tells the checks where tester keeps its files
+type Config struct {
+	ConfigDir string // e.g. /usr/local/etc/tester
+	CacheDir  string // XDG cache dir holding downloaded tests
+
+	// Free space thresholds for the cache dir
+	MinFreeBytes  uint64 // below this the check fails
+	WarnFreeBytes uint64 // below this the check warns
+}
+
+// minIsolateMajor is the first isolate release supporting cgroup v2
+const minIsolateMajor = 2
+
+// Run executes all environment checks in a fixed order.
+func Run(cfg Config) []Result {
+	return []Result{
+		checkIsolateVersion(),
+		checkCgroupV2(),
+		checkIsolateCg(),
+		checkSwap(),
+		checkCpuGovernor(),
+		checkAddressSpaceRandomisation(),
+		checkConfigFile(cfg.ConfigDir, "testlib.h", Fail),
+		checkConfigFile(cfg.ConfigDir, "system.txt", Warn),
+		checkFreeSpace(cfg.CacheDir, cfg.MinFreeBytes, cfg.WarnFreeBytes),
+	}
+}
+
+func checkIsolateVersion() Result {
+	res := Result{Name: "isolate version"}
+	version, err := isolate.Version()
+	if err != nil {
+		res.Level = Fail
+		res.Detail = err.Error()
+		return res
+	}
+	majorStr, _, _ := strings.Cut(version, ".")
+	major, err := strconv.Atoi(majorStr)
+	if err != nil {
+		res.Level = Warn
+		res.Detail = fmt.Sprintf("could not parse version %q", version)
+		return res
+	}
+	if major < minIsolateMajor {
+		res.Level = Fail
+		res.Detail = fmt.Sprintf("isolate %s is too old; need %d.0 or newer", version, minIsolateMajor)
+		return res
+	}
+	res.Level = OK
+	res.Detail = version
+	return res
+}
+
+func checkCgroupV2() Result {
+	res := Result{Name: "cgroup v2"}
+	data, err := os.ReadFile("/sys/fs/cgroup/cgroup.controllers")
+	if err != nil {
+		res.Level = Fail
+		res.Detail = "cgroup v2 hierarchy is not mounted at /sys/fs/cgroup"
+		return res
+	}
+	controllers := strings.Fields(string(data))
+	for _, required := range []string{"cpu", "memory", "pids"} {
+		found := false
+		for _, c := range controllers {
+			if c == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res.Level = Fail
+			res.Detail = fmt.Sprintf("controller %q is not available", required)
+			return res
+		}
+	}
+	res.Level = OK
+	res.Detail = strings.Join(controllers, " ")
+	return res
+}
+
+func checkIsolateCg() Result {
+	res := Result{Name: "isolate --cg"}
+	box, err := isolate.NewBox()
+	if err != nil {
+		res.Level = Fail
+		res.Detail = err.Error()
+		return res
+	}
+	if err := box.Close(); err != nil {
+		res.Level = Fail
+		res.Detail = err.Error()
+		return res
+	}
+	res.Level = OK
+	res.Detail = "box init and cleanup succeeded"
+	return res
+}
+
+func checkSwap() Result {
+	res := Result{Name: "swap"}
+	data, err := os.ReadFile("/proc/swaps")
+	if err != nil {
+		res.Level = Warn
+		res.Detail = err.Error()
+		return res
+	}
+	// the first line is a header
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > 1 {
+		res.Level = Warn
+		res.Detail = fmt.Sprintf("%d swap device(s) enabled; memory limits become unreliable", len(lines)-1)
+		return res
+	}
+	res.Level = OK
+	res.Detail = "disabled"
+	return res
+}
+
+func checkCpuGovernor() Result {
+	res := Result{Name: "cpu frequency governor"}
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu*/cpufreq/scaling_governor")
+	if len(paths) == 0 {
+		res.Level = OK
+		res.Detail = "frequency scaling not exposed"
+		return res
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			res.Level = Warn
+			res.Detail = err.Error()
+			return res
+		}
+		governor := strings.TrimSpace(string(data))
+		if governor != "performance" {
+			res.Level = Warn
+			res.Detail = fmt.Sprintf("%s uses %q; timings will vary", filepath.Base(filepath.Dir(filepath.Dir(p))), governor)
+			return res
+		}
+	}
+	res.Level = OK
+	res.Detail = "performance"
+	return res
+}
+
+func checkAddressSpaceRandomisation() Result {
+	res := Result{Name: "address space randomisation"}
+	data, err := os.ReadFile("/proc/sys/kernel/randomize_va_space")
+	if err != nil {
+		res.Level = Warn
+		res.Detail = err.Error()
+		return res
+	}
+	if value := strings.TrimSpace(string(data)); value != "0" {
+		res.Level = Warn
+		res.Detail = fmt.Sprintf("kernel.randomize_va_space=%s; runs are not reproducible", value)
+		return res
+	}
+	res.Level = OK
+	res.Detail = "disabled"
+	return res
+}
+
+func checkConfigFile(configDir string, name string, missing Level) Result {
+	res := Result{Name: name}
+	path := filepath.Join(configDir, name)
+	st, err := os.Stat(path)
+	if err != nil {
+		res.Level = missing
+		res.Detail = fmt.Sprintf("%s not found", path)
+		return res
+	}
+	if st.Size() == 0 {
+		res.Level = missing
+		res.Detail = fmt.Sprintf("%s is empty", path)
+		return res
+	}
+	res.Level = OK
+	res.Detail = path
+	return res
+}
+
+func checkFreeSpace(dir string, minFree uint64, warnFree uint64) Result {
+	res := Result{Name: "cache disk space"}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		res.Level = Fail
+		res.Detail = fmt.Sprintf("statfs %s: %v", dir, err)
+		return res
+	}
+	free := st.Bavail * uint64(st.Bsize)
+	res.Detail = fmt.Sprintf("%d MiB free in %s", free/(1024*1024), dir)
+	switch {
+	case free < minFree:
+		res.Level = Fail
+	case free < warnFree:
+		res.Level = Warn
+	default:
+		res.Level = OK
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package tester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/programme-lv/tester/api"
+)
+
+// Prefetch schedules the files into the cache and waits until all of them
+// are present or have failed. Files are not pinned; under a tight cache
+// budget they may be evicted again before they are used.
+func (t *Tester) Prefetch(ctx context.Context, req api.PrefetchReq) api.FinishPrefetch {
+	l := t.logger.With("prefetch", req.Uuid)
+	l.Info("start prefetch", "files", len(req.Files))
+
+	hostname, _ := os.Hostname()
+
+	var keys []string
+	failed := []string{}
+	for i, file := range req.Files {
+		key, err := t.prefetchFile(file)
+		if err != nil {
+			l.Warn("skip prefetch file", "index", i, "error", err)
+			if file.Sha256 != nil {
+				failed = append(failed, *file.Sha256)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	present := 0
+	for _, key := range keys {
+		if err := t.filestore.Wait(ctx, key); err != nil {
+			l.Warn("prefetch file", "sha", key[:8], "error", err)
+			failed = append(failed, key)
+			continue
+		}
+		present++
+	}
+
+	var errMsg *string
+	if err := ctx.Err(); err != nil {
+		msg := fmt.Sprintf("prefetch interrupted: %v", context.Cause(ctx))
+		errMsg = &msg
+	}
+
+	l.Info("prefetch completed", "present", present, "failed", len(failed))
+	return api.NewFinishPrefetch(req.Uuid, hostname, present, failed, errMsg)
+}
+
+// prefetchFile stores inline content or schedules the download
+// and returns the cache key
+func (t *Tester) prefetchFile(file api.File) (string, error) {
+	if file.Content != nil {
+		return t.filestore.Store([]byte(*file.Content))
+	}
+	if file.Sha256 == nil {
+		return "", errors.New("sha256 is nil")
+	}
+	if file.Url == nil {
+		if t.filestore.Exists(*file.Sha256) {
+			return *file.Sha256, nil
+		}
+		return "", errors.New("url is nil and file is not cached")
+	}
+	if err := t.filestore.Schedule(*file.Sha256, *file.Url); err != nil {
+		return "", fmt.Errorf("schedule file for download: %w", err)
+	}
+	return *file.Sha256, nil
+}
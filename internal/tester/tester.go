@@ -1,34 +1,58 @@
 package tester
 
 import (
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
 
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal"
 	"github.com/programme-lv/tester/internal/filecache"
+	"github.com/programme-lv/tester/internal/gatherer/multigath"
+	"github.com/programme-lv/tester/internal/gatherer/uploadgath"
+	"github.com/programme-lv/tester/internal/sandbox"
 	"github.com/programme-lv/tester/internal/testlib"
 )
 
+// IOMode selects how test input and output reach the submission
+type IOMode string
+
+const (
+	// IOFiles places the input in the box and lets the sandbox redirect
+	// the standard streams to files. Default for the checker variant.
+	IOFiles IOMode = "files"
+	// IOPipes streams input and output through Go pipes
+	IOPipes IOMode = "pipes"
+)
+
 type Tester struct {
+	sandbox      sandbox.Sandbox
 	filestore    *filecache.FileStore
 	systemInfo   string
 	tlibCheckers *testlib.TestlibCompiler
 	testlibHStr  string
+	ioMode       IOMode
+	observers    []observer
+	outputs      *uploadgath.Store
 	loggerOld    *log.Logger
 	logger       *slog.Logger
 }
 
 func NewTester(
+	sb sandbox.Sandbox,
 	filestore *filecache.FileStore,
 	tlibCheckers *testlib.TestlibCompiler,
 	systemInfoTxt string,
 	testlibHStr string) *Tester {
 	logger := log.New(os.Stdout, "Tester: ", log.LstdFlags|log.Lshortfile)
 	return &Tester{
+		sandbox:      sb,
 		filestore:    filestore,
 		systemInfo:   systemInfoTxt,
 		tlibCheckers: tlibCheckers,
 		testlibHStr:  testlibHStr,
+		ioMode:       IOFiles,
 		loggerOld:    logger,
 		logger:       slog.Default(),
 	}
@@ -40,3 +64,47 @@ func (t *Tester) SetLogger(l *slog.Logger) {
 		t.logger = l
 	}
 }
+
+// SetIOMode chooses how the checker variant feeds input and collects output.
+// The interactor variant always uses pipes.
+func (t *Tester) SetIOMode(mode IOMode) {
+	t.ioMode = mode
+}
+
+// SetOutputStore uploads the outputs that the gatherer of a job would only
+// see truncated, and links them in its runtime data; see uploadgath.
+func (t *Tester) SetOutputStore(store *uploadgath.Store) {
+	t.outputs = store
+}
+
+// Observer creates a gatherer that receives the events of a job next to
+// the job's own gatherer, e.g. to archive the results of every job
+type Observer func(req api.ExecReq) internal.ResultGatherer
+
+type observer struct {
+	name   string
+	create Observer
+}
+
+// observerBuffer is how many events may wait for a slow observer
+const observerBuffer = 1024
+
+// AddObserver lets a gatherer see the events of every job. Observers are
+// called from goroutines of their own, so that a slow one does not delay
+// the job; ExecTests returns once they have received its last event.
+func (t *Tester) AddObserver(name string, o Observer) {
+	t.observers = append(t.observers, observer{name: name, create: o})
+}
+
+// observe fans the events of a job out to its gatherer and the observers
+func (t *Tester) observe(gath internal.ResultGatherer, req api.ExecReq) (internal.ResultGatherer, func()) {
+	if len(t.observers) == 0 {
+		return gath, func() {}
+	}
+	sinks := []multigath.Sink{{Name: fmt.Sprintf("job %s", req.Uuid), Gatherer: gath}}
+	for _, o := range t.observers {
+		sinks = append(sinks, multigath.Sink{Name: o.name, Gatherer: o.create(req), Buffer: observerBuffer})
+	}
+	mg := multigath.New(sinks...)
+	return mg, mg.Close
+}
@@ -1,15 +1,20 @@
 package tester
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"strings"
 
 	"github.com/programme-lv/tester/api"
 	"github.com/programme-lv/tester/internal"
-	"github.com/programme-lv/tester/internal/isolate"
+	"github.com/programme-lv/tester/internal/filecache"
+	"github.com/programme-lv/tester/internal/gatherer/policygath"
+	"github.com/programme-lv/tester/internal/gatherer/uploadgath"
+	"github.com/programme-lv/tester/internal/sandbox"
 	"github.com/programme-lv/tester/internal/testlib"
 	"github.com/programme-lv/tester/internal/utils"
 	"golang.org/x/sync/errgroup"
@@ -17,16 +22,44 @@ import (
 
 var errCompileFailed = errors.New("compile failed")
 
-func (t *Tester) ExecTests(gath internal.ResultGatherer, req api.ExecReq) error {
+// ExecTests evaluates the submission on all tests of the request. Once ctx
+// is done, it stops waiting for test files and running further tests,
+// and reports an internal error. The gatherer only sees what the visibility
+// of the tests allows, with links to uploaded outputs if there is an output
+// store; the observers see everything.
+func (t *Tester) ExecTests(ctx context.Context, gath internal.ResultGatherer, req api.ExecReq) error {
+	gath = policygath.New(gath, req)
+	if t.outputs != nil {
+		gath = uploadgath.New(t.outputs, gath, req)
+	}
+	gath, closeObservers := t.observe(gath, req)
+	defer closeObservers()
+
 	// migrated to structured logging
-	l := t.logger.With("uuid", req.Uuid[0:8]+"...")
+	l := t.logger.With("uuid", req.Uuid[:min(8, len(req.Uuid))]+"...")
 	l.Info("start job", "lang", req.Lang.LangName,
 		"code_len", len(req.Code), "tests", len(req.Tests),
 		"cpu_sec", req.CpuMs/1000, "ram_mib", req.RamKiB/1024,
 		"checker", req.Checker != nil, "interactor", req.Interactor != nil)
 	gath.StartJob(t.systemInfo)
 
-	err := t.scheduleAndStoreTests(req.Tests)
+	if req.Archive != nil {
+		l.Info("awaiting test archive", "tests", len(req.Archive.Manifest))
+		tests, archivePinned, err := t.testsFromArchive(ctx, req)
+		defer t.filestore.Unpin(archivePinned...)
+		if err != nil {
+			msg := "get test archive"
+			l.Error(msg, "error", err)
+			err = fmt.Errorf("%s: %w", msg, err)
+			gath.InternalError(err.Error())
+			return err
+		}
+		req.Tests = tests
+	}
+
+	// keep this job's tests in the cache until it finishes
+	pinned, err := t.scheduleAndStoreTests(req.Tests)
+	defer t.filestore.Unpin(pinned...)
 	if err != nil {
 		msg := "schedule and store tests"
 		l.Error(msg, "error", err)
@@ -89,12 +122,12 @@ func (t *Tester) ExecTests(gath internal.ResultGatherer, req api.ExecReq) error
 
 	l.Info("starting tests")
 	if tlibChecker != nil {
-		if err := t.runCheckerVariant(gath, req, l, submFname, submContent, tlibChecker); err != nil {
+		if err := t.runCheckerVariant(ctx, gath, req, l, submFname, submContent, tlibChecker); err != nil {
 			return err
 		}
 	}
 	if tlibInteractor != nil {
-		if err := t.runInteractorVariant(gath, req, l, submFname, submContent, tlibInteractor); err != nil {
+		if err := t.runInteractorVariant(ctx, gath, req, l, submFname, submContent, tlibInteractor); err != nil {
 			return err
 		}
 	}
@@ -118,7 +151,7 @@ func (t *Tester) compileSubmission(req api.ExecReq, gath internal.ResultGatherer
 	l.Info("starting compilation", "lang", req.Lang.LangName)
 	gath.StartCompile()
 
-	compileBox, err := isolate.NewBox()
+	compileBox, err := t.sandbox.NewBox()
 	if err != nil {
 		errMsg := fmt.Errorf("create isolate box: %w", err)
 		l.Error("create isolate box", "error", err)
@@ -186,6 +219,7 @@ func (t *Tester) compileSubmission(req api.ExecReq, gath internal.ResultGatherer
 }
 
 func (t *Tester) runCheckerVariant(
+	ctx context.Context,
 	gath internal.ResultGatherer,
 	req api.ExecReq,
 	l *slog.Logger,
@@ -196,6 +230,12 @@ func (t *Tester) runCheckerVariant(
 	l.Info("running checker variant")
 	for i, test := range req.Tests {
 		testID := i + 1
+		if err := ctx.Err(); err != nil {
+			errMsg := fmt.Errorf("job cancelled: %w", context.Cause(ctx))
+			l.Error("job cancelled", "test_id", testID, "error", context.Cause(ctx))
+			gath.InternalError(errMsg.Error())
+			return errMsg
+		}
 		l.Info("start test", "test_id", testID)
 
 		if test.In.Sha256 == nil {
@@ -209,8 +249,8 @@ func (t *Tester) runCheckerVariant(
 			shaIn = shaIn[:8]
 		}
 		l.Info("awaiting input", "sha", shaIn)
-		input, err := t.filestore.Await(*test.In.Sha256)
-		if err != nil {
+		inputKey := *test.In.Sha256
+		if err := t.filestore.Wait(ctx, inputKey); err != nil {
 			errMsg := fmt.Errorf("get test input: %w", err)
 			l.Error("get test input", "error", err)
 			gath.InternalError(errMsg.Error())
@@ -228,17 +268,22 @@ func (t *Tester) runCheckerVariant(
 			shaAns = shaAns[:8]
 		}
 		l.Info("awaiting answer", "sha", shaAns)
-		answer, err := t.filestore.Await(*test.Ans.Sha256)
-		if err != nil {
+		answerKey := *test.Ans.Sha256
+		if err := t.filestore.Wait(ctx, answerKey); err != nil {
 			errMsg := fmt.Errorf("get test answer: %w", err)
 			l.Error("get test answer", "error", err)
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
 
-		gath.ReachTest(int64(testID), input, answer)
+		if err := t.reachTest(gath, int64(testID), inputKey, answerKey); err != nil {
+			errMsg := fmt.Errorf("read test head: %w", err)
+			l.Error("read test head", "error", err)
+			gath.InternalError(errMsg.Error())
+			return errMsg
+		}
 
-		submBox, err := isolate.NewBox()
+		submBox, err := t.sandbox.NewBox()
 		if err != nil {
 			errMsg := fmt.Errorf("create isolate box: %w", err)
 			l.Error("create isolate box", "error", err)
@@ -254,15 +299,7 @@ func (t *Tester) runCheckerVariant(
 			return errMsg
 		}
 
-		submCmd, err := submBox.Command(req.Lang.ExecCmd,
-			&isolate.Constraints{
-				CpuTimeLimInSec:      float64(req.CpuMs) / 1000,
-				ExtraCpuTimeLimInSec: 0.5,
-				WallTimeLimInSec:     20.0,
-				MemoryLimitInKB:      int64(req.RamKiB),
-				MaxProcesses:         256,
-				MaxOpenFiles:         256,
-			})
+		submData, err := t.runSubmission(submBox, req, inputKey)
 		if err != nil {
 			errMsg := fmt.Errorf("run submission: %w", err)
 			l.Error("run submission", "error", err)
@@ -270,14 +307,6 @@ func (t *Tester) runCheckerVariant(
 			return errMsg
 		}
 
-		submData, err := utils.RunIsolateCmd(submCmd, input)
-		if err != nil {
-			errMsg := fmt.Errorf("run submission: %w", err)
-			l.Error("collect submission runtime", "error", err)
-			gath.InternalError(errMsg.Error())
-			return errMsg
-		}
-
 		if submData.ExitSignal != nil {
 			l.Error("submission failed with signal", "test_id", testID, "signal", *submData.ExitSignal)
 			gath.FinishTest(int64(testID), submData, nil)
@@ -308,10 +337,8 @@ func (t *Tester) runCheckerVariant(
 			continue
 		}
 
-		output := submData.Stdout
-
 		l.Info("running checker", "test_id", testID)
-		checkerBox, err := isolate.NewBox()
+		checkerBox, err := t.sandbox.NewBox()
 		if err != nil {
 			errMsg := fmt.Errorf("create isolate box: %w", err)
 			l.Error("create isolate box", "error", err)
@@ -326,19 +353,19 @@ func (t *Tester) runCheckerVariant(
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
-		if err := checkerBox.AddFile("input.txt", input); err != nil {
+		if err := t.addTestFile(checkerBox, "input.txt", inputKey); err != nil {
 			errMsg := fmt.Errorf("add input to isolate box: %w", err)
 			l.Error("add input to box", "error", err)
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
-		if err := checkerBox.AddFile("output.txt", []byte(output)); err != nil {
+		if err := t.addSubmOutput(checkerBox, submBox, submData); err != nil {
 			errMsg := fmt.Errorf("add output to isolate box: %w", err)
 			l.Error("add output to box", "error", err)
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
-		if err := checkerBox.AddFile("answer.txt", answer); err != nil {
+		if err := t.addTestFile(checkerBox, "answer.txt", answerKey); err != nil {
 			errMsg := fmt.Errorf("add answer to isolate box: %w", err)
 			l.Error("add answer to box", "error", err)
 			gath.InternalError(errMsg.Error())
@@ -367,7 +394,120 @@ func (t *Tester) runCheckerVariant(
 	return nil
 }
 
+// reachTestHeadBytes is how much of the input and answer is read for
+// ReachTest; gatherers only show a small rectangle of it anyway.
+const reachTestHeadBytes = 64 * 1024
+
+// reachTest reports the test with the beginnings of its input and answer,
+// so that large tests are not loaded into memory.
+func (t *Tester) reachTest(gath internal.ResultGatherer, testID int64, inputKey string, answerKey string) error {
+	input, err := t.readTestHead(inputKey, reachTestHeadBytes)
+	if err != nil {
+		return err
+	}
+	answer, err := t.readTestHead(answerKey, reachTestHeadBytes)
+	if err != nil {
+		return err
+	}
+	gath.ReachTest(testID, input, answer)
+	return nil
+}
+
+func (t *Tester) readTestHead(key string, limit int64) ([]byte, error) {
+	r, err := t.filestore.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, limit))
+}
+
+// addTestFile places a cached test into the box. Files stored uncompressed
+// are hardlinked; compressed ones are decompressed into the box.
+func (t *Tester) addTestFile(box sandbox.Box, name string, key string) error {
+	if path, ok := t.filestore.RawPath(key); ok {
+		return box.LinkFile(name, path)
+	}
+	r, err := t.filestore.Open(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return box.AddFileFrom(name, r)
+}
+
+// Names of the submission's standard stream files in IOFiles mode
+const (
+	submStdinFname  = "stdin.txt"
+	submStdoutFname = "stdout.txt"
+	submStderrFname = "stderr.txt"
+)
+
+// runSubmission runs the submission on a single test input
+// using the configured I/O mode.
+func (t *Tester) runSubmission(submBox sandbox.Box, req api.ExecReq, inputKey string) (*api.RuntimeData, error) {
+	constraints := &sandbox.Constraints{
+		CpuTimeLimInSec:      float64(req.CpuMs) / 1000,
+		ExtraCpuTimeLimInSec: 0.5,
+		WallTimeLimInSec:     20.0,
+		MemoryLimitInKB:      int64(req.RamKiB),
+		MaxProcesses:         256,
+		MaxOpenFiles:         256,
+	}
+
+	if t.ioMode == IOPipes {
+		input, err := t.filestore.Get(inputKey)
+		if err != nil {
+			return nil, fmt.Errorf("read test input: %w", err)
+		}
+		submCmd, err := submBox.Command(req.Lang.ExecCmd, constraints)
+		if err != nil {
+			return nil, err
+		}
+		return utils.RunIsolateCmd(submCmd, input)
+	}
+
+	if err := t.addTestFile(submBox, submStdinFname, inputKey); err != nil {
+		return nil, fmt.Errorf("add input to isolate box: %w", err)
+	}
+	// reported from the cache: the submission may rewrite its stdin file
+	stdin, err := t.readTestHead(inputKey, utils.MaxCapturedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("read test input: %w", err)
+	}
+	files := sandbox.Streams{
+		Stdin:  submStdinFname,
+		Stdout: submStdoutFname,
+		Stderr: submStderrFname,
+	}
+	submCmd, err := submBox.CommandWithFiles(req.Lang.ExecCmd, constraints, files)
+	if err != nil {
+		return nil, err
+	}
+	return utils.RunIsolateCmdFiles(submBox, submCmd, files, stdin)
+}
+
+// addSubmOutput places the complete submission output into the checker box.
+// In IOFiles mode it is copied file to file without passing through memory.
+func (t *Tester) addSubmOutput(checkerBox sandbox.Box, submBox sandbox.Box, submData *api.RuntimeData) error {
+	if t.ioMode == IOPipes {
+		return checkerBox.AddFile("output.txt", []byte(submData.Stdout))
+	}
+
+	r, err := submBox.OpenFile(submStdoutFname)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// the submission removed its own output file
+			return checkerBox.AddFile("output.txt", nil)
+		}
+		return err
+	}
+	defer r.Close()
+	return checkerBox.AddFileFrom("output.txt", r)
+}
+
 func (t *Tester) runInteractorVariant(
+	ctx context.Context,
 	gath internal.ResultGatherer,
 	req api.ExecReq,
 	l *slog.Logger,
@@ -378,11 +518,17 @@ func (t *Tester) runInteractorVariant(
 	l.Info("running interactor variant")
 	for i, test := range req.Tests {
 		testID := i + 1
+		if err := ctx.Err(); err != nil {
+			errMsg := fmt.Errorf("job cancelled: %w", context.Cause(ctx))
+			l.Error("job cancelled", "test_id", testID, "error", context.Cause(ctx))
+			gath.InternalError(errMsg.Error())
+			return errMsg
+		}
 		l.Info("start test", "test_id", testID)
 
 		l.Info("awaiting input", "sha", *test.In.Sha256)
-		input, err := t.filestore.Await(*test.In.Sha256)
-		if err != nil {
+		inputKey := *test.In.Sha256
+		if err := t.filestore.Wait(ctx, inputKey); err != nil {
 			errMsg := fmt.Errorf("get test input: %w", err)
 			l.Error("get test input", "error", err)
 			gath.InternalError(errMsg.Error())
@@ -390,18 +536,23 @@ func (t *Tester) runInteractorVariant(
 		}
 
 		l.Info("awaiting answer", "sha", *test.Ans.Sha256)
-		answer, err := t.filestore.Await(*test.Ans.Sha256)
-		if err != nil {
+		answerKey := *test.Ans.Sha256
+		if err := t.filestore.Wait(ctx, answerKey); err != nil {
 			errMsg := fmt.Errorf("get test answer: %w", err)
 			l.Error("get test answer", "error", err)
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
 
-		gath.ReachTest(int64(testID), input, answer)
+		if err := t.reachTest(gath, int64(testID), inputKey, answerKey); err != nil {
+			errMsg := fmt.Errorf("read test head: %w", err)
+			l.Error("read test head", "error", err)
+			gath.InternalError(errMsg.Error())
+			return errMsg
+		}
 
 		l.Info("setting up isolate for submission")
-		submBox, err := isolate.NewBox()
+		submBox, err := t.sandbox.NewBox()
 		if err != nil {
 			errMsg := fmt.Errorf("create isolate box for submission: %w", err)
 			l.Error("create isolate box", "error", err)
@@ -418,7 +569,7 @@ func (t *Tester) runInteractorVariant(
 		}
 
 		l.Info("setting up isolate for interactor")
-		interactorBox, err := isolate.NewBox()
+		interactorBox, err := t.sandbox.NewBox()
 		if err != nil {
 			errMsg := fmt.Errorf("create isolate box for interactor: %w", err)
 			l.Error("create interactor box", "error", err)
@@ -433,13 +584,13 @@ func (t *Tester) runInteractorVariant(
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
-		if err := interactorBox.AddFile("input.txt", input); err != nil {
+		if err := t.addTestFile(interactorBox, "input.txt", inputKey); err != nil {
 			errMsg := fmt.Errorf("add input to isolate box: %w", err)
 			l.Error("add input to box", "error", err)
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
-		if err := interactorBox.AddFile("answer.txt", answer); err != nil {
+		if err := t.addTestFile(interactorBox, "answer.txt", answerKey); err != nil {
 			errMsg := fmt.Errorf("add answer to isolate box: %w", err)
 			l.Error("add answer to box", "error", err)
 			gath.InternalError(errMsg.Error())
@@ -454,7 +605,7 @@ func (t *Tester) runInteractorVariant(
 			return errMsg
 		}
 
-		submConstraints := &isolate.Constraints{
+		submConstraints := &sandbox.Constraints{
 			CpuTimeLimInSec:      float64(req.CpuMs) / 1000,
 			ExtraCpuTimeLimInSec: 0.5,
 			WallTimeLimInSec:     20.0,
@@ -548,21 +699,8 @@ func (t *Tester) runInteractorVariant(
 			gath.InternalError(errMsg.Error())
 			return errMsg
 		}
-		submissionRuntimeData := &api.RuntimeData{
-			Stdin:         submStdinStr.String(),
-			Stdout:        submStdoutStr.String(),
-			Stderr:        submStderrStr.String(),
-			ExitCode:      submMetrics.ExitCode,
-			CpuMillis:     submMetrics.CpuMillis,
-			WallMillis:    submMetrics.WallMillis,
-			RamKiBytes:    submMetrics.CgMemKb,
-			CtxSwV:        submMetrics.CswVoluntary,
-			CtxSwF:        submMetrics.CswForced,
-			ExitSignal:    submMetrics.ExitSig,
-			IsolateStatus: submMetrics.Status,
-			IsolateMsg:    submMetrics.Message,
-			CgOomKilled:   submMetrics.CgOomKilled,
-		}
+		submissionRuntimeData := utils.NewRuntimeData(submMetrics,
+			submStdinStr.String(), submStdoutStr.String(), submStderrStr.String())
 
 		interactorMetrics, err := interactorProcess.Wait()
 		if err != nil {
@@ -572,21 +710,8 @@ func (t *Tester) runInteractorVariant(
 			return errMsg
 		}
 
-		interactorRuntimeData := &api.RuntimeData{
-			Stdin:         submStdinStr.String(),
-			Stdout:        submStdinStr.String(),
-			Stderr:        interactorStderrStr.String(),
-			ExitCode:      interactorMetrics.ExitCode,
-			CpuMillis:     interactorMetrics.CpuMillis,
-			WallMillis:    interactorMetrics.WallMillis,
-			RamKiBytes:    interactorMetrics.CgMemKb,
-			IsolateStatus: interactorMetrics.Status,
-			CtxSwV:        interactorMetrics.CswVoluntary,
-			CtxSwF:        interactorMetrics.CswForced,
-			ExitSignal:    interactorMetrics.ExitSig,
-			IsolateMsg:    interactorMetrics.Message,
-			CgOomKilled:   interactorMetrics.CgOomKilled,
-		}
+		interactorRuntimeData := utils.NewRuntimeData(interactorMetrics,
+			submStdinStr.String(), submStdinStr.String(), interactorStderrStr.String())
 
 		l.Info("test finished", "test_id", testID)
 		gath.FinishTest(int64(testID), submissionRuntimeData, interactorRuntimeData)
@@ -594,11 +719,64 @@ func (t *Tester) runInteractorVariant(
 	return nil
 }
 
-func (t *Tester) scheduleAndStoreTests(tests []api.Test) error {
+// testsFromArchive downloads and unpacks the test archive of the request
+// and returns its tests by cache key. The archive and the unpacked files
+// stay pinned until the returned keys are unpinned.
+func (t *Tester) testsFromArchive(ctx context.Context, req api.ExecReq) ([]api.Test, []string, error) {
+	archive := req.Archive
+	if len(req.Tests) > 0 {
+		return nil, nil, errors.New("tests and test archive are mutually exclusive")
+	}
+	if archive.Sha256 == nil {
+		return nil, nil, errors.New("archive sha256 is nil")
+	}
+	key := *archive.Sha256
+
+	var pinned []string
+	t.filestore.Pin(key)
+	pinned = append(pinned, key)
+	if archive.Url != nil {
+		if err := t.filestore.Schedule(key, *archive.Url); err != nil {
+			return nil, pinned, fmt.Errorf("schedule archive for download: %w", err)
+		}
+	}
+
+	index, err := t.filestore.AwaitArchive(ctx, key)
+	if err != nil {
+		return nil, pinned, err
+	}
+	for _, fileKey := range index {
+		pinned = append(pinned, fileKey)
+	}
+
+	tests := make([]api.Test, 0, len(archive.Manifest))
+	for i, entry := range archive.Manifest {
+		inKey, ok := index[filecache.CleanArchivePath(entry.In)]
+		if !ok {
+			return nil, pinned, fmt.Errorf("test %d: input %q not in archive", i+1, entry.In)
+		}
+		ansKey, ok := index[filecache.CleanArchivePath(entry.Ans)]
+		if !ok {
+			return nil, pinned, fmt.Errorf("test %d: answer %q not in archive", i+1, entry.Ans)
+		}
+		tests = append(tests, api.Test{
+			In:         api.File{Sha256: &inKey},
+			Ans:        api.File{Sha256: &ansKey},
+			Visibility: entry.Visibility,
+		})
+	}
+	return tests, pinned, nil
+}
+
+// scheduleAndStoreTests makes the test files available in the file store
+// and pins them. It returns the pinned keys, also when it fails midway.
+func (t *Tester) scheduleAndStoreTests(tests []api.Test) ([]string, error) {
+	var pinned []string
 	for i := range tests {
 		test := &tests[i]
-		if (test.In.Url == nil && test.In.Content == nil) || (test.Ans.Url == nil && test.Ans.Content == nil) {
-			return errors.New("input or answer download url and content are nil")
+		if (test.In.Url == nil && test.In.Content == nil && test.In.Sha256 == nil) ||
+			(test.Ans.Url == nil && test.Ans.Content == nil && test.Ans.Sha256 == nil) {
+			return pinned, errors.New("input or answer download url, content and sha256 are nil")
 		}
 		if test.In.Content != nil {
 			var err error
@@ -607,15 +785,24 @@ func (t *Tester) scheduleAndStoreTests(tests []api.Test) error {
 			}
 			*test.In.Sha256, err = t.filestore.Store([]byte(*test.In.Content))
 			if err != nil {
-				return fmt.Errorf("store input content: %w", err)
+				return pinned, fmt.Errorf("store input content: %w", err)
 			}
+			t.filestore.Pin(*test.In.Sha256)
+			pinned = append(pinned, *test.In.Sha256)
 		} else {
 			if test.In.Sha256 == nil {
-				return errors.New("input sha256 is nil")
+				return pinned, errors.New("input sha256 is nil")
 			}
-			err := t.filestore.Schedule(*test.In.Sha256, *test.In.Url)
-			if err != nil {
-				return fmt.Errorf("schedule input file for download: %w", err)
+			t.filestore.Pin(*test.In.Sha256)
+			pinned = append(pinned, *test.In.Sha256)
+			if test.In.Url != nil {
+				err := t.filestore.Schedule(*test.In.Sha256, *test.In.Url)
+				if err != nil {
+					return pinned, fmt.Errorf("schedule input file for download: %w", err)
+				}
+			} else if !t.filestore.Exists(*test.In.Sha256) {
+				// files without url come from the test archive or an earlier job
+				return pinned, errors.New("input file is not cached and has no url")
 			}
 		}
 		if test.Ans.Content != nil {
@@ -625,18 +812,27 @@ func (t *Tester) scheduleAndStoreTests(tests []api.Test) error {
 			}
 			*test.Ans.Sha256, err = t.filestore.Store([]byte(*test.Ans.Content))
 			if err != nil {
-				return fmt.Errorf("store answer content: %w", err)
+				return pinned, fmt.Errorf("store answer content: %w", err)
 			}
+			t.filestore.Pin(*test.Ans.Sha256)
+			pinned = append(pinned, *test.Ans.Sha256)
 		} else {
 			if test.Ans.Sha256 == nil {
-				return errors.New("answer sha256 is nil")
+				return pinned, errors.New("answer sha256 is nil")
 			}
-			err := t.filestore.Schedule(*test.Ans.Sha256, *test.Ans.Url)
-			if err != nil {
-				return fmt.Errorf("schedule answer file for download: %w", err)
+			t.filestore.Pin(*test.Ans.Sha256)
+			pinned = append(pinned, *test.Ans.Sha256)
+			if test.Ans.Url != nil {
+				err := t.filestore.Schedule(*test.Ans.Sha256, *test.Ans.Url)
+				if err != nil {
+					return pinned, fmt.Errorf("schedule answer file for download: %w", err)
+				}
+			} else if !t.filestore.Exists(*test.Ans.Sha256) {
+				// files without url come from the test archive or an earlier job
+				return pinned, errors.New("answer file is not cached and has no url")
 			}
 		}
 	}
 
-	return nil
+	return pinned, nil
 }
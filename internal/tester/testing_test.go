@@ -0,0 +1,272 @@
+package tester
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/programme-lv/tester/api"
+	"github.com/programme-lv/tester/internal/filecache"
+	"github.com/programme-lv/tester/internal/gatherer/respbuilder"
+	"github.com/programme-lv/tester/internal/sandbox/fake"
+	"github.com/programme-lv/tester/internal/testlib"
+)
+
+func TestMain(m *testing.M) {
+	// compiled checkers and interactors are cached here across the tests
+	cache, err := os.MkdirTemp("", "tester-test-cache-*")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CACHE_HOME", cache)
+	os.Setenv("XDG_RUNTIME_DIR", cache)
+	code := m.Run()
+	os.RemoveAll(cache)
+	os.Exit(code)
+}
+
+var python = api.PrLang{
+	LangName:  "python3",
+	CodeFname: "main.py",
+	ExecCmd:   "python3 main.py",
+}
+
+var cpp = api.PrLang{
+	LangName:      "C++17",
+	CodeFname:     "main.cpp",
+	CompileCmd:    strPtr("g++ -std=c++17 -O2 -o main main.cpp"),
+	CompiledFname: strPtr("main"),
+	ExecCmd:       "./main",
+}
+
+const doubleCode = "n = int(input())\nprint(2 * n)\n"
+
+const doubleInteractor = `#include "testlib.h"
+
+int main(int argc, char* argv[]) {
+    registerInteraction(argc, argv);
+    int n = inf.readInt();
+    std::cout << n << std::endl;
+    int x = ouf.readInt();
+    if (x != 2 * n) quitf(_wa, "expected %d, got %d", 2 * n, x);
+    quitf(_ok, "doubled %d", n);
+}
+`
+
+func TestExecTestsFake(t *testing.T) {
+	for _, tool := range []string{"g++", "python3"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	testlibH, err := os.ReadFile(filepath.Join("..", "..", "scripts", "defaults", "testlib.h"))
+	if err != nil {
+		t.Fatalf("failed to read testlib.h: %v", err)
+	}
+
+	type fault struct{ match, status string }
+	type expect struct {
+		status api.ExecStatus
+		// per test: isolate status of the submission and the
+		// checker exit code, -1 if the checker must not have run
+		subm []api.IsolateStatus
+		chkr []int64
+		// reported stdin per test, not checked if nil
+		stdin []string
+	}
+	cases := []struct {
+		name       string
+		lang       api.PrLang
+		code       string
+		interactor *string
+		ioMode     IOMode
+		faults     []fault
+		expect     expect
+	}{
+		{
+			name:   "accepted",
+			lang:   python,
+			code:   doubleCode,
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{0, 0}},
+		},
+		{
+			name:   "accepted with pipes",
+			lang:   python,
+			code:   doubleCode,
+			ioMode: IOPipes,
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{0, 0}},
+		},
+		{
+			name:   "wrong answer",
+			lang:   python,
+			code:   "n = int(input())\nprint(n)\n",
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{1, 1}},
+		},
+		{
+			name: "forged stdin file",
+			lang: python,
+			code: "import os\nn = int(input())\nos.remove('stdin.txt')\nopen('stdin.txt', 'w').write('forged')\nprint(2 * n)\n",
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{0, 0},
+				stdin: []string{"1\n", "21\n"}},
+		},
+		{
+			name:   "runtime error",
+			lang:   python,
+			code:   "raise SystemExit(3)\n",
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateRuntimeError, api.IsolateRuntimeError}, chkr: []int64{-1, -1}},
+		},
+		{
+			name:   "injected time limit",
+			lang:   python,
+			code:   doubleCode,
+			faults: []fault{{"main.py", "TO"}},
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateTimedOut, api.IsolateTimedOut}, chkr: []int64{-1, -1}},
+		},
+		{
+			name:   "injected signal",
+			lang:   python,
+			code:   doubleCode,
+			faults: []fault{{"main.py", "SG"}},
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateSignaled, api.IsolateSignaled}, chkr: []int64{-1, -1}},
+		},
+		{
+			name:   "injected sandbox failure",
+			lang:   python,
+			code:   doubleCode,
+			faults: []fault{{"main.py", "XX"}},
+			// nothing was written, so the checker rejects the empty output
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateInternalError, api.IsolateInternalError}, chkr: []int64{1, 1}},
+		},
+		{
+			name:   "compiled",
+			lang:   cpp,
+			code:   "#include <iostream>\nint main() { int n; std::cin >> n; std::cout << 2 * n << std::endl; }\n",
+			expect: expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{0, 0}},
+		},
+		{
+			name:   "compile error",
+			lang:   cpp,
+			code:   "int main() { return x; }\n",
+			expect: expect{status: api.CompileError},
+		},
+		{
+			name:       "interactor",
+			lang:       python,
+			code:       doubleCode,
+			interactor: strPtr(doubleInteractor),
+			expect:     expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{0, 0}},
+		},
+		{
+			name:       "interactor wrong answer",
+			lang:       python,
+			code:       "n = int(input())\nprint(3 * n)\n",
+			interactor: strPtr(doubleInteractor),
+			expect:     expect{status: api.Success, subm: []api.IsolateStatus{api.IsolateOK, api.IsolateOK}, chkr: []int64{1, 1}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sb, err := fake.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("failed to create fake sandbox: %v", err)
+			}
+			for _, f := range c.faults {
+				sb.Inject(f.match, f.status, "")
+			}
+			dir := t.TempDir()
+			filestore := filecache.New(filepath.Join(dir, "files"), filepath.Join(dir, "tmp"))
+			tester := NewTester(sb, filestore, testlib.NewTestlibCompiler(sb), "fake", string(testlibH))
+			if c.ioMode != "" {
+				tester.SetIOMode(c.ioMode)
+			}
+
+			req := api.ExecReq{
+				Uuid: "00000000-0000-4000-8000-000000000000",
+				Code: c.code,
+				Lang: c.lang,
+				Tests: []api.Test{
+					{In: api.File{Content: strPtr("1\n")}, Ans: api.File{Content: strPtr("2\n")}},
+					{In: api.File{Content: strPtr("21\n")}, Ans: api.File{Content: strPtr("42\n")}},
+				},
+				Interactor: c.interactor,
+				CpuMs:      1000,
+				RamKiB:     256 * 1024,
+			}
+			rb := respbuilder.New(req.Uuid)
+			if err := tester.ExecTests(context.Background(), rb, req); err != nil {
+				t.Fatalf("ExecTests: %v", err)
+			}
+			resp := rb.Response()
+
+			if resp.Status != c.expect.status {
+				msg := ""
+				if resp.ErrorMsg != nil {
+					msg = *resp.ErrorMsg
+				}
+				t.Fatalf("status %s, want %s (%s)", resp.Status, c.expect.status, msg)
+			}
+			if len(resp.TestResults) != len(c.expect.subm) {
+				t.Fatalf("%d test results, want %d", len(resp.TestResults), len(c.expect.subm))
+			}
+			for i, res := range resp.TestResults {
+				if got := res.Subm.Status(); got != c.expect.subm[i] {
+					t.Errorf("test %d: submission status %s, want %s", i+1, got, c.expect.subm[i])
+				}
+				if c.expect.stdin != nil && res.Subm.Stdin != c.expect.stdin[i] {
+					t.Errorf("test %d: stdin %q, want %q", i+1, res.Subm.Stdin, c.expect.stdin[i])
+				}
+				want := c.expect.chkr[i]
+				switch {
+				case want < 0 && res.Chkr != nil:
+					t.Errorf("test %d: checker ran, want it skipped", i+1)
+				case want >= 0 && res.Chkr == nil:
+					t.Errorf("test %d: checker did not run", i+1)
+				case want >= 0 && res.Chkr.ExitCode != want:
+					t.Errorf("test %d: checker exit code %d, want %d (%s)", i+1, res.Chkr.ExitCode, want, res.Chkr.Stderr)
+				}
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestExecTestsShortUuid(t *testing.T) {
+	for _, tool := range []string{"g++", "python3"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	testlibH, err := os.ReadFile(filepath.Join("..", "..", "scripts", "defaults", "testlib.h"))
+	if err != nil {
+		t.Fatalf("failed to read testlib.h: %v", err)
+	}
+	sb, err := fake.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create fake sandbox: %v", err)
+	}
+	dir := t.TempDir()
+	filestore := filecache.New(filepath.Join(dir, "files"), filepath.Join(dir, "tmp"))
+	tester := NewTester(sb, filestore, testlib.NewTestlibCompiler(sb), "fake", string(testlibH))
+
+	req := api.ExecReq{
+		Uuid:   "x",
+		Code:   doubleCode,
+		Lang:   python,
+		Tests:  []api.Test{{In: api.File{Content: strPtr("1\n")}, Ans: api.File{Content: strPtr("2\n")}}},
+		CpuMs:  1000,
+		RamKiB: 256 * 1024,
+	}
+	rb := respbuilder.New(req.Uuid)
+	if err := tester.ExecTests(context.Background(), rb, req); err != nil {
+		t.Fatalf("ExecTests: %v", err)
+	}
+	if resp := rb.Response(); resp.Status != api.Success {
+		t.Fatalf("status %s, want %s", resp.Status, api.Success)
+	}
+}
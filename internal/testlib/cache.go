@@ -0,0 +1,126 @@
+package testlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/programme-lv/tester/internal/xdg"
+)
+
+// Compiled binaries are cached by the sha256 of their source code as
+// <sha>, next to the compilation log <sha>.log.json and the source <sha>.cpp.
+var cachedSuffixes = []string{"", ".log.json", ".cpp"}
+
+// CachedBinary describes a compiled checker or interactor
+type CachedBinary struct {
+	Kind    string // "checker" or "interactor"
+	Sha256  string // of the source code
+	Size    int64  // of the binary, log and source together
+	LastUse time.Time
+}
+
+// CacheDirs returns the directories of compiled binaries by their kind
+func CacheDirs() map[string]string {
+	xdgDirs := xdg.NewXDGDirs()
+	return map[string]string{
+		"checker":    xdgDirs.AppCacheDir("tester/checkers"),
+		"interactor": xdgDirs.AppCacheDir("tester/interactors"),
+	}
+}
+
+// ListCache returns the cached binaries, most recently used first.
+func ListCache() ([]CachedBinary, error) {
+	var bins []CachedBinary
+	for kind, dir := range CacheDirs() {
+		dirEntries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s cache: %w", kind, err)
+		}
+		for _, de := range dirEntries {
+			name := de.Name()
+			if de.IsDir() || strings.Contains(name, ".") {
+				continue
+			}
+			info, err := de.Info()
+			if err != nil {
+				continue
+			}
+			bin := CachedBinary{Kind: kind, Sha256: name, LastUse: info.ModTime()}
+			for _, suffix := range cachedSuffixes {
+				if st, err := os.Stat(filepath.Join(dir, name+suffix)); err == nil {
+					bin.Size += st.Size()
+				}
+			}
+			bins = append(bins, bin)
+		}
+	}
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i].LastUse.After(bins[j].LastUse)
+	})
+	return bins, nil
+}
+
+// RemoveCached deletes the binaries compiled from the source with the
+// given sha256. Reports whether any were found.
+func RemoveCached(sha string) (bool, error) {
+	found := false
+	for kind, dir := range CacheDirs() {
+		for _, suffix := range cachedSuffixes {
+			err := os.Remove(filepath.Join(dir, sha+suffix))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return found, fmt.Errorf("failed to remove %s %s: %w", kind, sha, err)
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// VerifyCache checks that every cached binary has its source and that
+// the source hashes to the binary's name. Other binaries are moved to
+// quarantineDir. Returns the names of the moved binaries.
+func VerifyCache(quarantineDir string) ([]string, error) {
+	bins, err := ListCache()
+	if err != nil {
+		return nil, err
+	}
+
+	var bad []string
+	for _, bin := range bins {
+		dir := CacheDirs()[bin.Kind]
+		src, err := os.ReadFile(filepath.Join(dir, bin.Sha256+".cpp"))
+		if err == nil && getStringSha256(string(src)) == bin.Sha256 {
+			continue
+		}
+
+		dst := filepath.Join(quarantineDir, bin.Kind+"s")
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return bad, fmt.Errorf("failed to create directory %s: %w", dst, err)
+		}
+		for _, suffix := range cachedSuffixes {
+			name := bin.Sha256 + suffix
+			err := os.Rename(filepath.Join(dir, name), filepath.Join(dst, name))
+			if err != nil && !os.IsNotExist(err) {
+				return bad, fmt.Errorf("failed to quarantine %s %s: %w", bin.Kind, bin.Sha256, err)
+			}
+		}
+		bad = append(bad, bin.Kind+" "+bin.Sha256)
+	}
+	return bad, nil
+}
+
+// touchCached marks a cached binary as just used
+func touchCached(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
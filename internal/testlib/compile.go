@@ -9,27 +9,30 @@ import (
 	"sync"
 
 	"github.com/programme-lv/tester/api"
-	"github.com/programme-lv/tester/internal/isolate"
+	"github.com/programme-lv/tester/internal/sandbox"
 	"github.com/programme-lv/tester/internal/utils"
 	"github.com/programme-lv/tester/internal/xdg"
 )
 
 type TestlibCompiler struct {
+	sandbox       sandbox.Sandbox
 	checkerDir    string
 	interactorDir string
 
 	lock sync.Mutex
 }
 
-func NewTestlibCompiler() *TestlibCompiler {
+func NewTestlibCompiler(sb sandbox.Sandbox) *TestlibCompiler {
 	// Initialize XDG directories
 	xdgDirs := xdg.NewXDGDirs()
 
 	// Use XDG cache directory for compiled checkers and interactors
 	// These are cached compiled binaries that can be regenerated
+	dirs := CacheDirs()
 	tc := &TestlibCompiler{
-		checkerDir:    xdgDirs.AppCacheDir("tester/checkers"),
-		interactorDir: xdgDirs.AppCacheDir("tester/interactors"),
+		sandbox:       sb,
+		checkerDir:    dirs["checker"],
+		interactorDir: dirs["interactor"],
 	}
 
 	err := xdgDirs.EnsureDir(tc.checkerDir)
@@ -51,10 +54,11 @@ func (tc *TestlibCompiler) CompileChecker(sourceCode string, testlibHeaderStr st
 	defer tc.lock.Unlock()
 	compiledPath := filepath.Join(tc.checkerDir, sourceCodeSha256)
 	if _, err := os.Stat(compiledPath); err == nil {
+		touchCached(compiledPath)
 		return os.ReadFile(compiledPath)
 	}
 
-	compiled, runData, err := compile(sourceCode, testlibHeaderStr)
+	compiled, runData, err := compile(tc.sandbox, sourceCode, testlibHeaderStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compile checker: %w", err)
 	}
@@ -93,10 +97,11 @@ func (tc *TestlibCompiler) CompileInteractor(sourceCode string, testlibHeaderStr
 	defer tc.lock.Unlock()
 	compiledPath := filepath.Join(tc.interactorDir, sourceCodeSha256)
 	if _, err := os.Stat(compiledPath); err == nil {
+		touchCached(compiledPath)
 		return os.ReadFile(compiledPath)
 	}
 
-	compiled, runData, err := compile(sourceCode, testlibHeaderStr)
+	compiled, runData, err := compile(tc.sandbox, sourceCode, testlibHeaderStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compile interactor: %w", err)
 	}
@@ -139,16 +144,15 @@ const srcCodeFname = "checker.cpp"
 const compileCmd = "g++ -std=c++17 -o checker checker.cpp -I . -I /usr/include"
 const compiledFname = "checker"
 
-func compile(code string, testlibHeaderStr string) (compiled []byte, runData *api.RuntimeData, err error) {
-	isolateInstance := isolate.GetInstance()
-	var box *isolate.Box
-	box, err = isolateInstance.NewBox()
+func compile(sb sandbox.Sandbox, code string, testlibHeaderStr string) (compiled []byte, runData *api.RuntimeData, err error) {
+	var box sandbox.Box
+	box, err = sb.NewBox()
 	if err != nil {
 		err = fmt.Errorf("failed to create isolate box: %w", err)
 		return
 	}
 
-	defer func(box *isolate.Box) {
+	defer func(box sandbox.Box) {
 		_ = box.Close()
 	}(box)
 
@@ -164,7 +168,7 @@ func compile(code string, testlibHeaderStr string) (compiled []byte, runData *ap
 		return
 	}
 
-	var iCmd *isolate.Cmd
+	var iCmd sandbox.Cmd
 	iCmd, err = box.Command(compileCmd, nil)
 	if err != nil {
 		err = fmt.Errorf("failed to create isolate command: %w ", err)
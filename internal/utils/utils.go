@@ -2,15 +2,19 @@ package utils
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"strconv"
+	"strings"
 
 	"github.com/programme-lv/tester/api"
-	"github.com/programme-lv/tester/internal/isolate"
+	"github.com/programme-lv/tester/internal/sandbox"
 	"golang.org/x/sync/errgroup"
 )
 
-func RunIsolateCmd(p *isolate.Cmd, input []byte) (*api.RuntimeData, error) {
+func RunIsolateCmd(p sandbox.Cmd, input []byte) (*api.RuntimeData, error) {
 	var eg errgroup.Group
 
 	err := p.Start()
@@ -57,19 +61,166 @@ func RunIsolateCmd(p *isolate.Cmd, input []byte) (*api.RuntimeData, error) {
 		return nil, fmt.Errorf("wait for isolate command: %w", err)
 	}
 
+	return NewRuntimeData(metrics, string(input), string(stdout), string(stderr)), nil
+}
+
+// MaxCapturedBytes caps how much of each redirected stream is read back
+// from disk into runtime data. Gatherers show far less than this anyway.
+const MaxCapturedBytes = 1 << 20 // 1 MiB
+
+// RunIsolateCmdFiles runs a command created with Box.CommandWithFiles.
+// The stream files stay in the box; only the beginnings of stdout and stderr
+// are read back. The program may rewrite its stdin file, so the caller passes
+// the beginning of the input it placed there instead.
+func RunIsolateCmdFiles(box sandbox.Box, p sandbox.Cmd, files sandbox.Streams, stdin []byte) (*api.RuntimeData, error) {
+	var eg errgroup.Group
+
+	err := p.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start isolate command: %w", err)
+	}
+
+	// the sandbox process itself is still attached to pipes; drain them
+	_ = p.Stdin().Close()
+	eg.Go(func() error {
+		_, _ = io.Copy(io.Discard, p.Stdout())
+		return nil
+	})
+	eg.Go(func() error {
+		_, _ = io.Copy(io.Discard, p.Stderr())
+		return nil
+	})
+
+	err = eg.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("wait for isolate command: %w", err)
+	}
+
+	metrics, err := p.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("wait for isolate command: %w", err)
+	}
+
+	stdout, stdoutSize, err := ReadBoxFileHead(box, files.Stdout, MaxCapturedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("read stdout file: %w", err)
+	}
+	stderr, stderrSize, err := ReadBoxFileHead(box, files.Stderr, MaxCapturedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("read stderr file: %w", err)
+	}
+
+	data := NewRuntimeData(metrics, string(stdin), stdout, stderr)
+	if stdoutSize > int64(len(stdout)) {
+		data.StdoutOrigSize = stdoutSize
+	}
+	if stderrSize > int64(len(stderr)) {
+		data.StderrOrigSize = stderrSize
+	}
+	return data, nil
+}
+
+// ReadBoxFileHead reads at most limit bytes from the start of a box file
+// and returns them with the size of the whole file. An empty path or
+// a missing file (the program may delete it) reads as "".
+func ReadBoxFileHead(box sandbox.Box, path string, limit int64) (string, int64, error) {
+	if path == "" {
+		return "", 0, nil
+	}
+	r, err := box.OpenFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+	defer r.Close()
+	head, err := io.ReadAll(io.LimitReader(r, limit))
+	if err != nil {
+		return "", 0, err
+	}
+	// the sandbox bounds the file size, so counting the rest is cheap enough
+	rest, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(head), int64(len(head)) + rest, nil
+}
+
+// NewRuntimeData combines sandbox metrics with the captured process streams.
+func NewRuntimeData(metrics *sandbox.Metrics, stdin, stdout, stderr string) *api.RuntimeData {
 	return &api.RuntimeData{
-		Stdin:         string(input),
-		Stdout:        string(stdout),
-		Stderr:        string(stderr),
+		Stdin:         stdin,
+		Stdout:        stdout,
+		Stderr:        stderr,
 		ExitCode:      metrics.ExitCode,
 		CpuMillis:     metrics.CpuMillis,
 		WallMillis:    metrics.WallMillis,
 		RamKiBytes:    metrics.CgMemKb,
-		IsolateStatus: metrics.Status,
+		MaxRssKiB:     metrics.MaxRssKb,
 		CtxSwV:        metrics.CswVoluntary,
 		CtxSwF:        metrics.CswForced,
 		ExitSignal:    metrics.ExitSig,
-		IsolateMsg:    metrics.Message,
 		CgOomKilled:   metrics.CgOomKilled,
-	}, nil
+		Killed:        metrics.Killed,
+		IsolateStatus: isolateStatus(metrics.Status),
+		IsolateMsg:    metrics.Message,
+		IsolateMeta:   metrics.FullReport,
+	}
+}
+
+// isolateStatus maps the meta file status to the api enum.
+// Isolate omits the status line when the program exited normally.
+func isolateStatus(status *string) *api.IsolateStatus {
+	if status == nil || *status == "" {
+		return nil
+	}
+	s := api.IsolateStatus(*status)
+	return &s
+}
+
+// ParseByteSize parses sizes like "512", "64K", "20GiB" or "1.5T".
+// Suffixes are binary: K = 1024 bytes, M = 1024 K, and so on.
+func ParseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "IB")
+	str = strings.TrimSuffix(str, "B")
+
+	multiplier := int64(1)
+	if str != "" {
+		switch str[len(str)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			str = str[:len(str)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatByteSize is the inverse of ParseByteSize, e.g. 1536 is "1.5K".
+func FormatByteSize(n int64) string {
+	const units = "KMGT"
+	if n < 1<<10 {
+		return strconv.FormatInt(n, 10)
+	}
+	value := float64(n)
+	unit := -1
+	for value >= 1<<10 && unit < len(units)-1 {
+		value /= 1 << 10
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + string(units[unit])
 }
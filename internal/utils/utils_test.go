@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/programme-lv/tester/internal/sandbox"
+	"github.com/programme-lv/tester/internal/sandbox/fake"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"0", 0},
+		{"64K", 64 << 10},
+		{"64kb", 64 << 10},
+		{"20GiB", 20 << 30},
+		{" 3 M ", 3 << 20},
+		{"1.5T", 3 << 39},
+		{"100B", 100},
+	}
+	for _, c := range cases {
+		got, err := ParseByteSize(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", c.in, got, err, c.want)
+		}
+	}
+
+	for _, in := range []string{"", "G", "-1K", "ten", "5X", "1.2.3M"} {
+		if got, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	cases := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0"},
+		{1023, "1023"},
+		{1024, "1.0K"},
+		{1536, "1.5K"},
+		{20 << 30, "20.0G"},
+		{2048 << 40, "2048.0T"},
+	}
+	for _, c := range cases {
+		if got := FormatByteSize(c.in); got != c.want {
+			t.Errorf("FormatByteSize(%d) = %q, want %q", c.in, got, c.want)
+		}
+		if back, err := ParseByteSize(c.want); err != nil || back != c.in {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", c.want, back, err, c.in)
+		}
+	}
+}
+
+func newTestBox(t *testing.T) sandbox.Box {
+	t.Helper()
+	sb, err := fake.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create fake sandbox: %v", err)
+	}
+	box, err := sb.NewBox()
+	if err != nil {
+		t.Fatalf("failed to create box: %v", err)
+	}
+	t.Cleanup(func() { box.Close() })
+	return box
+}
+
+func TestReadBoxFileHead(t *testing.T) {
+	box := newTestBox(t)
+	if err := box.AddFile("out", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path     string
+		limit    int64
+		wantHead string
+		wantSize int64
+	}{
+		{"out", 4, "0123", 10},
+		{"out", 10, "0123456789", 10},
+		{"out", 100, "0123456789", 10},
+		{"missing", 4, "", 0},
+		{"", 4, "", 0},
+	}
+	for _, c := range cases {
+		head, size, err := ReadBoxFileHead(box, c.path, c.limit)
+		if err != nil || head != c.wantHead || size != c.wantSize {
+			t.Errorf("ReadBoxFileHead(%q, %d) = %q, %d, %v; want %q, %d",
+				c.path, c.limit, head, size, err, c.wantHead, c.wantSize)
+		}
+	}
+}
+
+func TestRunIsolateCmdFilesCapsOutput(t *testing.T) {
+	box := newTestBox(t)
+	const written = MaxCapturedBytes + 1000
+	files := sandbox.Streams{Stdout: "stdout", Stderr: "stderr"}
+	command := fmt.Sprintf("head -c %d /dev/zero | tr '\\0' a; echo oops >&2", written)
+	cmd, err := box.CommandWithFiles(command, nil, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := RunIsolateCmdFiles(box, cmd, files, []byte("1 2\n"))
+	if err != nil {
+		t.Fatalf("RunIsolateCmdFiles: %v", err)
+	}
+	if len(data.Stdout) != MaxCapturedBytes || strings.Trim(data.Stdout, "a") != "" {
+		t.Errorf("captured %d bytes of stdout, want the first %d", len(data.Stdout), MaxCapturedBytes)
+	}
+	if data.StdoutOrigSize != written {
+		t.Errorf("StdoutOrigSize = %d, want %d", data.StdoutOrigSize, written)
+	}
+	// a stream captured whole has no original size
+	if data.Stderr != "oops\n" || data.StderrOrigSize != 0 {
+		t.Errorf("stderr = %q with original size %d, want \"oops\\n\" and 0", data.Stderr, data.StderrOrigSize)
+	}
+	if data.Stdin != "1 2\n" {
+		t.Errorf("stdin = %q, want the input passed in", data.Stdin)
+	}
+
+	// the whole output stays in the box for the checker
+	head, size, err := ReadBoxFileHead(box, "stdout", written)
+	if err != nil || int64(len(head)) != written || size != written {
+		t.Errorf("stdout file holds %d of %d bytes, %v; want %d", len(head), size, err, written)
+	}
+}